@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/osm/qwbs/internal/qw/command"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+const (
+	probeTimeout = time.Second * 5
+	probeBufSize = 64
+)
+
+// checkConfig implements the check-config command, which validates the
+// configuration loaded the same way as by the service, i.e. including flags
+// and environment variables, and optionally probes the masters and writers
+// without starting the service. It returns the exit code.
+func checkConfig(args []string) int {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	probe := flags.Bool("probe", false, "Probe masters and webhooks")
+	timeout := flags.Duration("timeout", probeTimeout, "Timeout for each probe")
+	opts := parseFlags(flags, args)
+
+	conf, configFile, _, err := opts.load()
+	if err != nil {
+		var joined interface{ Unwrap() []error }
+		if errors.As(err, &joined) {
+			for _, e := range joined.Unwrap() {
+				fmt.Fprintf(os.Stderr, "error: %v\n", e)
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+		return 1
+	}
+	defer conf.CloseWriters()
+
+	for _, w := range conf.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+
+	if configFile == "" {
+		configFile = "no config file"
+	}
+	fmt.Printf("%s: listen address %s, %d masters, %d writers\n",
+		configFile, conf.ListenAddress, len(conf.MasterAddresses), len(conf.Writers))
+
+	if !*probe {
+		return 0
+	}
+
+	failed := false
+	for _, addr := range conf.MasterAddresses {
+		if err := probeMaster(addr, *timeout); err != nil {
+			fmt.Fprintf(os.Stderr, "error: master %s: %v\n", addr, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("master %s: ok\n", addr)
+	}
+
+	for i, w := range conf.Writers {
+		p, ok := w.(writer.Prober)
+		if !ok {
+			continue
+		}
+
+		// The writer spec may contain secrets, so only the type is shown.
+		name := fmt.Sprintf("writer %d (%s)", i+1, strings.Fields(conf.WriterSpecs[i])[0])
+
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		err := p.Probe(ctx)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: ok\n", name)
+	}
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
+
+// probeMaster pings the master server and waits for the acknowledgement.
+func probeMaster(addr *net.UDPAddr, timeout time.Duration) error {
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	if _, err := conn.Write(command.GetPingBytes()); err != nil {
+		return err
+	}
+
+	buf := make([]byte, probeBufSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+
+	if cmd, _ := command.Parse(buf[:n]); cmd != command.ACK {
+		return fmt.Errorf("unexpected response %q", buf[:n])
+	}
+
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/osm/qwbs/internal/config"
+)
+
+// convertConfig implements the convert-config command, which prints a line
+// based config file in a structured format. It returns the exit code.
+func convertConfig(args []string) int {
+	flags := flag.NewFlagSet("convert-config", flag.ExitOnError)
+	configFile := flags.String("config-file", "./qwbs.conf", "Path to config file")
+	format := flags.String("format", config.FormatYAML, "Output format, yaml or toml")
+	flags.Parse(args)
+
+	b, err := config.Convert(*configFile, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to convert config file: %v\n", err)
+		return 1
+	}
+
+	os.Stdout.Write(b)
+	return 0
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/osm/qwbs/internal/config"
+)
+
+const (
+	defaultConfigFile = "./qwbs.conf"
+	envPrefix         = "QWBS_"
+)
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+type options struct {
+	configFile    string
+	listenAddress string
+	masters       stringList
+	writers       stringList
+	debug         bool
+	logFormat     string
+	logLevel      string
+	logOutput     string
+	set           map[string]bool
+}
+
+// parseFlags parses the options from args using flags, which may already
+// hold flags of a subcommand.
+func parseFlags(flags *flag.FlagSet, args []string) *options {
+	opts := &options{set: make(map[string]bool)}
+
+	flags.StringVar(&opts.configFile, "config-file", defaultConfigFile,
+		"Path to config file (env QWBS_CONFIG_FILE)")
+	flags.StringVar(&opts.listenAddress, "listen-address", "",
+		"Address to listen on (env QWBS_LISTEN_ADDRESS)")
+	flags.Var(&opts.masters, "master-address",
+		"Master server address, can be repeated (env QWBS_MASTER_ADDRESS, comma separated)")
+	flags.Var(&opts.writers, "writer",
+		"Writer as in the config file, e.g. \"slogger format=json\", can be repeated (env QWBS_WRITER, newline separated)")
+	flags.BoolVar(&opts.debug, "debug", false,
+		"Enable debug logging (env QWBS_DEBUG)")
+	flags.StringVar(&opts.logFormat, "log-format", "",
+		"Log format, text or json (env QWBS_LOG_FORMAT)")
+	flags.StringVar(&opts.logLevel, "log-level", "",
+		"Log levels, e.g. \"info master=debug\" (env QWBS_LOG_LEVEL)")
+	flags.StringVar(&opts.logOutput, "log-output", "",
+		"Log output, stderr, stdout or a file path (env QWBS_LOG_OUTPUT)")
+	flags.Parse(args)
+
+	flags.Visit(func(f *flag.Flag) {
+		opts.set[f.Name] = true
+	})
+
+	return opts
+}
+
+// load loads the configuration with the precedence flags, environment
+// variables, config file. Master addresses and writers given as flags or
+// environment variables replace the ones in the config file. The config file
+// is optional when the default path doesn't exist, which allows running
+// without one, e.g. in containers.
+func (o *options) load() (*config.Config, string, []config.Override, error) {
+	path := o.configFile
+	if !o.set["config-file"] {
+		if v, ok := os.LookupEnv(envPrefix + "CONFIG_FILE"); ok {
+			path = v
+		} else if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			path = ""
+		}
+	}
+
+	overrides := o.overrides()
+	conf, err := config.Load(path, overrides)
+	return conf, path, overrides, err
+}
+
+func (o *options) overrides() []config.Override {
+	var overrides []config.Override
+	add := func(source, opt, value string) {
+		overrides = append(overrides, config.NewOverride(source, opt, value))
+	}
+
+	scalars := []struct {
+		opt   string
+		flag  string
+		value string
+	}{
+		{"listen_address", "listen-address", o.listenAddress},
+		{"debug", "debug", strconv.FormatBool(o.debug)},
+		{"log_format", "log-format", o.logFormat},
+		{"log_level", "log-level", o.logLevel},
+		{"log_output", "log-output", o.logOutput},
+	}
+
+	for _, s := range scalars {
+		env := envPrefix + strings.ToUpper(s.opt)
+
+		if o.set[s.flag] {
+			add("flag -"+s.flag, s.opt, s.value)
+		} else if v, ok := os.LookupEnv(env); ok {
+			add(env, s.opt, v)
+		}
+	}
+
+	masters := []string(o.masters)
+	mastersSource := "flag -master-address"
+	if len(masters) == 0 {
+		mastersSource = envPrefix + "MASTER_ADDRESS"
+		masters = splitEnv(mastersSource, ",")
+	}
+
+	for _, m := range masters {
+		add(mastersSource, "master_address", m)
+	}
+
+	writers := []string(o.writers)
+	writersSource := "flag -writer"
+	if len(writers) == 0 {
+		writersSource = envPrefix + "WRITER"
+		writers = splitEnv(writersSource, "\n")
+	}
+
+	for _, w := range writers {
+		add(writersSource, "writer", w)
+	}
+
+	return overrides
+}
+
+func splitEnv(name, sep string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(name), sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
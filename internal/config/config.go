@@ -1,84 +1,381 @@
 package config
 
 import (
-	"bufio"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
+	"github.com/osm/qwbs/internal/logging"
+	"github.com/osm/qwbs/internal/qw/master"
+	"github.com/osm/qwbs/internal/tracing"
 	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/database"
+	"github.com/osm/qwbs/internal/writer/irc"
+	"github.com/osm/qwbs/internal/writer/live"
+	"github.com/osm/qwbs/internal/writer/matrix"
+	"github.com/osm/qwbs/internal/writer/mqtt"
+	"github.com/osm/qwbs/internal/writer/nats"
 	"github.com/osm/qwbs/internal/writer/poster"
+	"github.com/osm/qwbs/internal/writer/redis"
+	"github.com/osm/qwbs/internal/writer/rotate"
+	"github.com/osm/qwbs/internal/writer/slack"
 	"github.com/osm/qwbs/internal/writer/slogger"
+	"github.com/osm/qwbs/internal/writer/telegram"
+	"github.com/osm/qwbs/internal/writer/tmpl"
 )
 
 type Config struct {
 	Debug           bool
+	HTTPAddress     string
+	HistorySize     int
+	Logging         logging.Options
 	ListenAddress   *net.UDPAddr
 	MasterAddresses []*net.UDPAddr
+	MasterOptions   []master.Options
+	Tracing         *tracing.Options
 	Writers         []writer.Writer
+	WriterSpecs     []string
+	WriterFilters   []writer.Filter
+	Warnings        []string
 }
 
-func FromFile(path string) (*Config, error) {
+// LineError is an error found on a specific line of a config file.
+type LineError struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Override is an option given outside of the config file, e.g. as a command
+// line flag or environment variable, source describes where it came from.
+type Override struct {
+	Source string
+	fields []field
+}
+
+// NewOverride creates an override from an option and a value, which is split
+// into arguments the same way as in the config file.
+func NewOverride(source, opt, value string) Override {
+	return Override{Source: source, fields: append([]field{plainField(opt)}, splitFields(value)...)}
+}
+
+// Load parses the config file at path, unless path is empty, and applies the
+// overrides on top of it. Parsing continues past invalid lines so that all
+// errors are reported at once, joined into the returned error. Options that
+// can be given multiple times, i.e. master addresses and writers, replace
+// those from the file when overridden.
+func Load(path string, overrides []Override) (*Config, error) {
+	p := &parser{
+		conf:        &Config{},
+		masterLines: make(map[string]string),
+		writerLines: make(map[string]string),
+		included:    make(map[string]bool),
+	}
+
+	if path != "" {
+		if err := p.parseFile(path); err != nil {
+			p.conf.CloseWriters()
+			return nil, err
+		}
+	}
+
+	overridden := p.applyOverrides(overrides)
+
+	conf := p.conf
+
+	// The debug option sets the default level unless given by log_level in
+	// the same or a higher precedence source, i.e. an overridden debug takes
+	// precedence over log_level in the file.
+	if conf.Logging.Levels == nil {
+		conf.Logging.Levels = make(map[string]slog.Level)
+	}
+	if _, ok := conf.Logging.Levels[""]; !ok || overridden["debug"] && !overridden["log_level"] {
+		conf.Logging.Levels[""] = slog.LevelInfo
+		if conf.Debug {
+			conf.Logging.Levels[""] = slog.LevelDebug
+		}
+	}
+
+	if conf.ListenAddress == nil {
+		p.errs = append(p.errs, fmt.Errorf("no listen address found in the configuration"))
+	}
+
+	if len(conf.MasterAddresses) == 0 {
+		p.errs = append(p.errs, fmt.Errorf("no master servers found in the configuration"))
+	}
+
+	if len(conf.Writers) == 0 {
+		p.errs = append(p.errs, fmt.Errorf("no writers found in the configuration"))
+	}
+
+	// A live feed without an address of its own is served on http_address.
+	served := 0
+	for _, w := range conf.Writers {
+		if l, ok := w.(*live.Live); ok && l.Address() == "" {
+			served++
+		}
+	}
+	if served > 0 && conf.HTTPAddress == "" {
+		p.errs = append(p.errs, fmt.Errorf("writer live requires an address option or http_address"))
+	}
+	if served > 1 {
+		p.errs = append(p.errs, fmt.Errorf("only one writer live can be served on http_address"))
+	}
+
+	// The writers that were created before an error are closed, since
+	// some of them, e.g. sql, hold resources from when they're created.
+	if len(p.errs) > 0 {
+		conf.CloseWriters()
+		return nil, errors.Join(p.errs...)
+	}
+
+	return conf, nil
+}
+
+// CloseWriters closes the writers of a configuration that won't be run.
+func (c *Config) CloseWriters() {
+	for _, w := range c.Writers {
+		w.Close()
+	}
+}
+
+// applyOverrides applies the overrides and returns the overridden options.
+func (p *parser) applyOverrides(overrides []Override) map[string]bool {
+	replaced := make(map[string]bool)
+
+	for _, o := range overrides {
+		opt := o.fields[0].String()
+		if !replaced[opt] {
+			replaced[opt] = true
+
+			switch opt {
+			case "master_address":
+				p.conf.MasterAddresses = nil
+				p.conf.MasterOptions = nil
+				p.masterLines = make(map[string]string)
+			case "writer":
+				p.conf.CloseWriters()
+				p.conf.Writers = nil
+				p.conf.WriterSpecs = nil
+				p.conf.WriterFilters = nil
+				p.writerLines = make(map[string]string)
+			}
+		}
+
+		if err := p.apply("", o.Source, o.fields); err != nil {
+			p.errs = append(p.errs, fmt.Errorf("%s: %w", o.Source, err))
+		}
+	}
+
+	return replaced
+}
+
+type parser struct {
+	conf        *Config
+	errs        []error
+	masterLines map[string]string
+	writerLines map[string]string
+	included    map[string]bool
+}
+
+func (p *parser) parseFile(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if p.included[abs] {
+		return fmt.Errorf("%s is included recursively", path)
+	}
+	p.included[abs] = true
+	defer delete(p.included, abs)
+
+	if format := structuredFormat(path); format != "" {
+		return p.parseStructuredFile(path, format)
+	}
+
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer file.Close()
 
-	conf := &Config{}
-	scanner := bufio.NewScanner(file)
+	options, err := readOptions(path, file)
+	if err != nil {
+		return err
+	}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
+	for _, o := range options {
+		if o.err != nil {
+			p.errs = append(p.errs, o.err)
 			continue
 		}
 
-		fields := strings.Fields(line)
+		loc := fmt.Sprintf("%s:%d", path, o.line)
+		if err := p.apply(path, loc, o.fields); err != nil {
+			p.errs = append(p.errs, &LineError{Path: path, Line: o.line, Err: err})
+		}
+	}
+
+	return nil
+}
+
+// apply expands and parses a single option, loc describes where the option
+// was defined for duplicate warnings.
+func (p *parser) apply(path, loc string, fields []field) error {
+	opt := fields[0].String()
+	args, err := expandArgs(fields[1:])
+	if err == nil {
+		err = p.parseOption(path, opt, args)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing %q: %w", opt, err)
+	}
+
+	switch opt {
+	case "master_address":
+		key := p.conf.MasterAddresses[len(p.conf.MasterAddresses)-1].String()
+		p.warnDuplicate(loc, p.masterLines, key, "master_address "+key)
+	case "writer":
+		p.warnDuplicate(loc, p.writerLines, strings.Join(args, " "), "writer")
+	}
+
+	return nil
+}
+
+type fileOption struct {
+	line   int
+	fields []field
+	err    error
+}
+
+// readOptions reads the options of a line based config file without
+// expanding them. Errors are returned per option so that parsing can
+// continue past invalid lines.
+func readOptions(path string, r io.Reader) ([]fileOption, error) {
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []fileOption
+	for i := 0; i < len(lines); i++ {
+		lineNum := lines[i].num
+
+		fields := splitFields(lines[i].text)
+
 		if len(fields) == 0 {
 			continue
 		}
 
-		opt := fields[0]
-		args := fields[1:]
-		switch opt {
-		case "listen_address":
-			err = conf.parseListenAddress(args)
-		case "master_address":
-			err = conf.parseMasterAddress(args)
-		case "debug":
-			err = conf.parseDebug(args)
-		case "writer":
-			err = conf.parseWriter(args)
-		default:
-			err = fmt.Errorf("unknown config option: %q", opt)
-		}
-		if err != nil {
-			return nil, fmt.Errorf("error parsing %q: %w", opt, err)
+		// An option ending with an unquoted { continues in a block
+		// until a line with a single }, each line in between holds
+		// arguments.
+		if fields[len(fields)-1].is("{") {
+			var blockErr error
+			fields, i, blockErr = readBlock(lines, i, fields[:len(fields)-1])
+			if blockErr != nil {
+				options = append(options, fileOption{line: lineNum, err: &LineError{Path: path, Line: lineNum, Err: blockErr}})
+				continue
+			}
 		}
+
+		options = append(options, fileOption{line: lineNum, fields: fields})
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	return options, nil
+}
+
+func (p *parser) parseOption(path, opt string, args []string) error {
+	conf := p.conf
+
+	switch opt {
+	case "include":
+		return p.parseInclude(path, args)
+	case "listen_address":
+		return conf.parseListenAddress(args)
+	case "master_address":
+		return conf.parseMasterAddress(args)
+	case "debug":
+		return conf.parseDebug(args)
+	case "history_size":
+		return conf.parseHistorySize(args)
+	case "http_address":
+		return conf.parseHTTPAddress(args)
+	case "log_format":
+		return conf.parseLogFormat(args)
+	case "log_level":
+		return conf.parseLogLevel(args)
+	case "log_output":
+		return conf.parseLogOutput(args)
+	case "log_sampling":
+		return conf.parseLogSampling(args)
+	case "tracing":
+		return conf.parseTracing(args)
+	case "writer":
+		return conf.parseWriter(args)
+	default:
+		return fmt.Errorf("unknown config option: %q", opt)
 	}
+}
 
-	if conf.ListenAddress == nil {
-		return nil, fmt.Errorf("no listen address found in the configuration")
+// parseInclude parses the files matching the glob patterns, relative paths
+// are resolved from the directory of the including file.
+func (p *parser) parseInclude(path string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("include requires at least one argument")
 	}
 
-	if len(conf.MasterAddresses) == 0 {
-		return nil, fmt.Errorf("no master servers found in the configuration")
+	for _, pattern := range args {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+
+		// Glob returns the matches in lexical order, which allows the
+		// order of included files to be controlled by their names.
+		for _, match := range matches {
+			if err := p.parseFile(match); err != nil {
+				return err
+			}
+		}
 	}
 
-	if len(conf.Writers) == 0 {
-		return nil, fmt.Errorf("no writers found in the configuration")
+	return nil
+}
+
+func (p *parser) warnDuplicate(loc string, seen map[string]string, key, what string) {
+	if first, ok := seen[key]; ok {
+		p.conf.Warnings = append(p.conf.Warnings, fmt.Sprintf("%s: duplicate %s, first defined at %s",
+			loc, what, first))
+		return
 	}
 
-	return conf, nil
+	seen[key] = loc
 }
 
 func (c *Config) parseDebug(args []string) error {
@@ -95,6 +392,117 @@ func (c *Config) parseDebug(args []string) error {
 	return nil
 }
 
+func (c *Config) parseHistorySize(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("history_size requires exactly one argument")
+	}
+
+	v, err := strconv.Atoi(args[0])
+	if err != nil || v <= 0 {
+		return fmt.Errorf("invalid history_size value: %q", args[0])
+	}
+
+	c.HistorySize = v
+	return nil
+}
+
+func (c *Config) parseLogFormat(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("log_format requires exactly one argument")
+	}
+
+	switch args[0] {
+	case "text", "json":
+		c.Logging.Format = args[0]
+	default:
+		return fmt.Errorf("unknown log format: %q", args[0])
+	}
+
+	return nil
+}
+
+// parseLogLevel parses the default level and component=level pairs, e.g.
+// "info master=debug writers=warn".
+func (c *Config) parseLogLevel(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("log_level requires at least one argument")
+	}
+
+	levels := make(map[string]slog.Level)
+	for _, arg := range args {
+		component, name, ok := strings.Cut(arg, "=")
+		if !ok {
+			component, name = "", arg
+		} else if component == "default" {
+			component = ""
+		} else if !slices.Contains(logging.Components, component) {
+			return fmt.Errorf("unknown log component %q, expected one of %s",
+				component, strings.Join(logging.Components, ", "))
+		}
+
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return fmt.Errorf("invalid log level %q", name)
+		}
+		levels[component] = level
+	}
+
+	c.Logging.Levels = levels
+	return nil
+}
+
+func (c *Config) parseLogOutput(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("log_output requires exactly one argument")
+	}
+
+	c.Logging.Output = args[0]
+	return nil
+}
+
+func (c *Config) parseLogSampling(args []string) error {
+	sampling := &logging.Sampling{}
+	var err error
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "interval=") {
+			v := strings.TrimPrefix(arg, "interval=")
+			sampling.Interval, err = time.ParseDuration(v)
+			if err != nil || sampling.Interval <= 0 {
+				return fmt.Errorf("invalid interval %q", v)
+			}
+		} else if strings.HasPrefix(arg, "burst=") {
+			v := strings.TrimPrefix(arg, "burst=")
+			sampling.Burst, err = strconv.Atoi(v)
+			if err != nil || sampling.Burst <= 0 {
+				return fmt.Errorf("invalid burst %q", v)
+			}
+		} else {
+			return fmt.Errorf("unknown log_sampling option: %q", arg)
+		}
+	}
+
+	if sampling.Interval == 0 || sampling.Burst == 0 {
+		return fmt.Errorf("log_sampling requires the interval and burst options")
+	}
+
+	c.Logging.Sampling = sampling
+	return nil
+}
+
+func (c *Config) parseHTTPAddress(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("http_address requires exactly one argument")
+	}
+
+	if _, err := net.ResolveTCPAddr("tcp", args[0]); err != nil {
+		return fmt.Errorf("http_address %q can't be resolved: %w", args[0], err)
+	}
+
+	c.HTTPAddress = args[0]
+	return nil
+}
+
 func (c *Config) parseListenAddress(args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("listen_address requires exactly one argument")
@@ -109,9 +517,26 @@ func (c *Config) parseListenAddress(args []string) error {
 	return nil
 }
 
+// parseMasterAddress parses the address of a master server followed by its
+// settings, e.g. "master.quakeworld.nu:27000 heartbeat_interval=5m".
 func (c *Config) parseMasterAddress(args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("master_server requires exactly one argument")
+	if len(args) == 0 {
+		return fmt.Errorf("master_address requires at least one argument")
+	}
+
+	var opts master.Options
+	var err error
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "heartbeat_interval=") {
+			v := strings.TrimPrefix(arg, "heartbeat_interval=")
+			opts.HeartbeatInterval, err = time.ParseDuration(v)
+			if err != nil || opts.HeartbeatInterval <= 0 {
+				return fmt.Errorf("invalid heartbeat_interval %q", v)
+			}
+		} else {
+			return fmt.Errorf("unknown master_address option: %q", arg)
+		}
 	}
 
 	addr, err := net.ResolveUDPAddr("udp", args[0])
@@ -120,53 +545,230 @@ func (c *Config) parseMasterAddress(args []string) error {
 	}
 
 	c.MasterAddresses = append(c.MasterAddresses, addr)
+	c.MasterOptions = append(c.MasterOptions, opts)
 	return nil
 }
 
+func (c *Config) parseTracing(args []string) error {
+	opts := &tracing.Options{}
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "endpoint=") {
+			opts.Endpoint = strings.TrimPrefix(arg, "endpoint=")
+		} else if strings.HasPrefix(arg, "service_name=") {
+			opts.ServiceName = strings.TrimPrefix(arg, "service_name=")
+		} else if strings.HasPrefix(arg, "header=") {
+			v := strings.TrimPrefix(arg, "header=")
+			name, value, ok := strings.Cut(v, ":")
+			if !ok || strings.TrimSpace(name) == "" {
+				return fmt.Errorf("invalid header %q, expected name:value", v)
+			}
+			if opts.Header == nil {
+				opts.Header = make(http.Header)
+			}
+			opts.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		} else {
+			return fmt.Errorf("unknown tracing option: %q", arg)
+		}
+	}
+
+	if opts.Endpoint == "" {
+		return fmt.Errorf("tracing requires an endpoint option")
+	}
+
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid tracing endpoint %q", opts.Endpoint)
+	}
+
+	c.Tracing = opts
+	return nil
+}
+
+// parseWriter parses a writer and records its arguments as the writer spec,
+// which identifies writers with an unchanged configuration on reload. The
+// spec includes a digest of the template files so that edits to them are
+// picked up as well.
 func (c *Config) parseWriter(args []string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("writer requires at least one argument")
 	}
 
+	// The filter options apply to all writer types, so they're removed
+	// before the writer options are parsed.
+	var filter writer.Filter
+	writerArgs := []string{args[0]}
+	for _, arg := range args[1:] {
+		v, ok := strings.CutPrefix(arg, "filter=")
+		if !ok {
+			writerArgs = append(writerArgs, arg)
+			continue
+		}
+
+		key, value, _ := strings.Cut(v, ":")
+		switch {
+		case value == "":
+			return fmt.Errorf("invalid filter %q, expected server:address or mode:mode", v)
+		case key == "server":
+			filter.Servers = append(filter.Servers, value)
+		case key == "mode":
+			filter.Modes = append(filter.Modes, value)
+		default:
+			return fmt.Errorf("unknown filter %q, expected server or mode", key)
+		}
+	}
+
+	n := len(c.Writers)
+	if err := c.parseWriterType(writerArgs); err != nil {
+		return err
+	}
+
+	spec := writerSpec(args)
+	for range c.Writers[n:] {
+		c.WriterSpecs = append(c.WriterSpecs, spec)
+		c.WriterFilters = append(c.WriterFilters, filter)
+	}
+
+	return nil
+}
+
+// writerSpec returns the spec that identifies a writer on reload. The options
+// are sorted by key, keeping the order of repeated options, so that the spec
+// doesn't depend on how the options are ordered in the config file. The
+// contents of template files are included as a hash so that changing a
+// template restarts the writer.
+func writerSpec(args []string) string {
+	opts := slices.Clone(args[1:])
+	slices.SortStableFunc(opts, func(a, b string) int {
+		ka, _, _ := strings.Cut(a, "=")
+		kb, _, _ := strings.Cut(b, "=")
+		return strings.Compare(ka, kb)
+	})
+
+	spec := strings.Join(append([]string{args[0]}, opts...), " ")
+	for _, arg := range opts {
+		if path, ok := strings.CutPrefix(arg, "template="); ok {
+			if b, err := os.ReadFile(path); err == nil {
+				spec += fmt.Sprintf(" %s@%x", path, sha256.Sum256(b))
+			}
+		}
+	}
+
+	return spec
+}
+
+func (c *Config) parseWriterType(args []string) error {
 	typ := args[0]
 	switch typ {
 	case "slogger":
 		return c.parseWriterSlogger(args)
 	case "poster":
 		return c.parseWriterPoster(args)
+	case "redis":
+		return c.parseWriterRedis(args)
+	case "irc":
+		return c.parseWriterIRC(args)
+	case "live":
+		return c.parseWriterLive(args)
+	case "matrix":
+		return c.parseWriterMatrix(args)
+	case "mqtt":
+		return c.parseWriterMQTT(args)
+	case "nats":
+		return c.parseWriterNATS(args)
+	case "slack":
+		return c.parseWriterSlack(args)
+	case "sql":
+		return c.parseWriterSQL(args)
+	case "telegram":
+		return c.parseWriterTelegram(args)
+	case "file":
+		return c.parseWriterFile(args)
 	default:
 		return fmt.Errorf("unknown writer type: %q", typ)
 	}
 }
 
 func (c *Config) parseWriterSlogger(args []string) error {
+	return c.parseWriterLog("slogger", "output", args)
+}
+
+func (c *Config) parseWriterFile(args []string) error {
+	return c.parseWriterLog("file", "path", args)
+}
+
+func (c *Config) parseWriterLog(typ, outputOpt string, args []string) error {
 	var format string
 	var output string
+	var t *template.Template
+	var opts rotate.Options
+	var err error
 
-	if len(args) >= 2 {
-		for _, arg := range args[1:] {
-			if strings.HasPrefix(arg, "format=") {
-				format = strings.TrimPrefix(arg, "format=")
-			} else if strings.HasPrefix(arg, "output=") {
-				output = strings.TrimPrefix(arg, "output=")
-			} else {
-				return fmt.Errorf("unknown slogger option: %q", arg)
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "format=") {
+			format = strings.TrimPrefix(arg, "format=")
+		} else if strings.HasPrefix(arg, outputOpt+"=") {
+			output = strings.TrimPrefix(arg, outputOpt+"=")
+		} else if strings.HasPrefix(arg, "template=") {
+			t, err = tmpl.ParseFile(strings.TrimPrefix(arg, "template="))
+			if err != nil {
+				return err
+			}
+		} else if strings.HasPrefix(arg, "template_text=") {
+			t, err = tmpl.Parse("template_text", strings.TrimPrefix(arg, "template_text="))
+			if err != nil {
+				return err
+			}
+		} else if strings.HasPrefix(arg, "max_size=") {
+			v := strings.TrimPrefix(arg, "max_size=")
+			opts.MaxSize, err = parseSize(v)
+			if err != nil {
+				return fmt.Errorf("invalid max_size %q: %w", v, err)
 			}
+		} else if strings.HasPrefix(arg, "max_age=") {
+			v := strings.TrimPrefix(arg, "max_age=")
+			opts.MaxAge, err = time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid max_age %q: %w", v, err)
+			}
+		} else if strings.HasPrefix(arg, "keep=") {
+			v := strings.TrimPrefix(arg, "keep=")
+			opts.Keep, err = strconv.Atoi(v)
+			if err != nil || opts.Keep < 0 {
+				return fmt.Errorf("invalid keep %q", v)
+			}
+		} else if strings.HasPrefix(arg, "compress=") {
+			v := strings.TrimPrefix(arg, "compress=")
+			opts.Compress, err = strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid compress value %q: %w", v, err)
+			}
+		} else {
+			return fmt.Errorf("unknown %s option: %q", typ, arg)
 		}
 	}
 
+	rotation := opts != rotate.Options{}
+
 	var w io.Writer
+	var file *rotate.File
 	switch output {
-	case "", "stderr":
+	case "", "stderr", "stdout":
+		if typ == "file" {
+			return fmt.Errorf("writer file requires a path option")
+		}
+		if rotation {
+			return fmt.Errorf("%s rotation options require a file %s", typ, outputOpt)
+		}
+
 		w = os.Stderr
-	case "stdout":
-		w = os.Stdout
-	default:
-		f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-		if err != nil {
-			return fmt.Errorf("failed to open log file %q: %w", output, err)
+		if output == "stdout" {
+			w = os.Stdout
 		}
-		w = f
+	default:
+		opts.Path = output
+		file = rotate.New(opts)
+		w = file
 	}
 
 	var handler slog.Handler
@@ -176,16 +778,60 @@ func (c *Config) parseWriterSlogger(args []string) error {
 	case "json":
 		handler = slog.NewJSONHandler(w, nil)
 	default:
-		return fmt.Errorf("slogger format must be either text or json")
+		return fmt.Errorf("%s format must be either text or json", typ)
+	}
+
+	c.Writers = append(c.Writers, slogger.New(slog.New(handler), t, file))
+	return nil
+}
+
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GB", 1 << 30}, {"G", 1 << 30},
+		{"MB", 1 << 20}, {"M", 1 << 20},
+		{"KB", 1 << 10}, {"K", 1 << 10},
+		{"B", 1},
+	}
+
+	multiplier := int64(1)
+	upper := strings.ToUpper(s)
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper = strings.TrimSuffix(upper, u.suffix)
+			multiplier = u.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if n <= 0 {
+		return 0, fmt.Errorf("size must be positive")
+	}
+
+	return n * multiplier, nil
+}
+
+// validateHTTPURL checks that v is an absolute http or https URL.
+func validateHTTPURL(name, v string) error {
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s %q must be an http or https URL", name, v)
 	}
 
-	c.Writers = append(c.Writers, slogger.New(slog.New(handler)))
 	return nil
 }
 
 func (c *Config) parseWriterPoster(args []string) error {
 	var format poster.Format
 	var url string
+	var opts poster.Options
 	var err error
 
 	if len(args) < 3 {
@@ -202,11 +848,529 @@ func (c *Config) parseWriterPoster(args []string) error {
 			}
 		} else if strings.HasPrefix(arg, "url=") {
 			url = strings.TrimPrefix(arg, "url=")
+		} else if strings.HasPrefix(arg, "template=") {
+			opts.Template, err = tmpl.ParseFile(strings.TrimPrefix(arg, "template="))
+			if err != nil {
+				return err
+			}
+		} else if strings.HasPrefix(arg, "template_text=") {
+			opts.Template, err = tmpl.Parse("template_text", strings.TrimPrefix(arg, "template_text="))
+			if err != nil {
+				return err
+			}
+		} else if strings.HasPrefix(arg, "username=") {
+			opts.Username = strings.TrimPrefix(arg, "username=")
+		} else if strings.HasPrefix(arg, "avatar_url=") {
+			opts.AvatarURL = strings.TrimPrefix(arg, "avatar_url=")
+		} else if strings.HasPrefix(arg, "connect_url=") {
+			opts.ConnectURL = strings.TrimPrefix(arg, "connect_url=")
+		} else if strings.HasPrefix(arg, "method=") {
+			opts.Method = strings.ToUpper(strings.TrimPrefix(arg, "method="))
+		} else if strings.HasPrefix(arg, "header=") {
+			v := strings.TrimPrefix(arg, "header=")
+			name, value, ok := strings.Cut(v, ":")
+			if !ok || strings.TrimSpace(name) == "" {
+				return fmt.Errorf("invalid header %q, expected name:value", v)
+			}
+			if opts.Header == nil {
+				opts.Header = make(http.Header)
+			}
+			opts.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		} else if strings.HasPrefix(arg, "bearer=") {
+			opts.BearerToken = strings.TrimPrefix(arg, "bearer=")
+		} else if strings.HasPrefix(arg, "basic_auth=") {
+			v := strings.TrimPrefix(arg, "basic_auth=")
+			var ok bool
+			opts.BasicUser, opts.BasicPassword, ok = strings.Cut(v, ":")
+			if !ok || opts.BasicUser == "" {
+				return fmt.Errorf("invalid basic_auth, expected user:password")
+			}
+		} else if strings.HasPrefix(arg, "hmac_secret=") {
+			opts.HMACSecret = strings.TrimPrefix(arg, "hmac_secret=")
+		} else if strings.HasPrefix(arg, "edit_window=") {
+			v := strings.TrimPrefix(arg, "edit_window=")
+			opts.EditWindow, err = time.ParseDuration(v)
+			if err != nil || opts.EditWindow <= 0 {
+				return fmt.Errorf("invalid edit_window %q, expected a positive duration", v)
+			}
 		} else {
 			return fmt.Errorf("unknown poster option: %q", arg)
 		}
 	}
 
-	c.Writers = append(c.Writers, poster.New(url, format))
+	switch opts.Method {
+	case "", http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return fmt.Errorf("poster method must be either POST, PUT or PATCH")
+	}
+
+	if opts.BearerToken != "" && opts.BasicUser != "" {
+		return fmt.Errorf("poster bearer and basic_auth options are mutually exclusive")
+	}
+
+	if url == "" {
+		return fmt.Errorf("writer poster requires a url option")
+	}
+
+	if err := validateHTTPURL("url", url); err != nil {
+		return err
+	}
+
+	if opts.Template != nil && format == poster.Unknown {
+		format = poster.Template
+	}
+
+	if format == poster.Template && opts.Template == nil {
+		return fmt.Errorf("poster format template requires a template option")
+	}
+
+	if opts.Template != nil && format != poster.Template {
+		return fmt.Errorf("poster template option requires format template")
+	}
+
+	if (opts.Username != "" || opts.AvatarURL != "" || opts.ConnectURL != "" || opts.EditWindow != 0) && format != poster.Discord {
+		return fmt.Errorf("poster username, avatar_url, connect_url and edit_window options require format discord")
+	}
+
+	// Discord rejects embeds with links other than http and https, such as
+	// qw:// links.
+	if opts.ConnectURL != "" {
+		if err := validateHTTPURL("connect_url", opts.ConnectURL); err != nil {
+			return err
+		}
+	}
+
+	c.Writers = append(c.Writers, poster.New(url, format, &opts))
+	return nil
+}
+
+func (c *Config) parseWriterSlack(args []string) error {
+	var url string
+	var t *template.Template
+	var err error
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "url=") {
+			url = strings.TrimPrefix(arg, "url=")
+		} else if strings.HasPrefix(arg, "template=") {
+			t, err = tmpl.ParseFile(strings.TrimPrefix(arg, "template="))
+			if err != nil {
+				return err
+			}
+		} else if strings.HasPrefix(arg, "template_text=") {
+			t, err = tmpl.Parse("template_text", strings.TrimPrefix(arg, "template_text="))
+			if err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("unknown slack option: %q", arg)
+		}
+	}
+
+	if url == "" {
+		return fmt.Errorf("writer slack requires a url option")
+	}
+
+	c.Writers = append(c.Writers, slack.New(url, t))
+	return nil
+}
+
+func (c *Config) parseWriterTelegram(args []string) error {
+	var api string
+	var token string
+	var chats []string
+	var t *template.Template
+	var err error
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "api=") {
+			api = strings.TrimPrefix(arg, "api=")
+		} else if strings.HasPrefix(arg, "token=") {
+			token = strings.TrimPrefix(arg, "token=")
+		} else if strings.HasPrefix(arg, "chat=") {
+			chats = append(chats, strings.TrimPrefix(arg, "chat="))
+		} else if strings.HasPrefix(arg, "template=") {
+			t, err = tmpl.ParseFile(strings.TrimPrefix(arg, "template="))
+			if err != nil {
+				return err
+			}
+		} else if strings.HasPrefix(arg, "template_text=") {
+			t, err = tmpl.Parse("template_text", strings.TrimPrefix(arg, "template_text="))
+			if err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("unknown telegram option: %q", arg)
+		}
+	}
+
+	if token == "" {
+		return fmt.Errorf("writer telegram requires a token option")
+	}
+
+	if len(chats) == 0 {
+		return fmt.Errorf("writer telegram requires at least one chat option")
+	}
+
+	c.Writers = append(c.Writers, telegram.New(api, token, chats, t))
+	return nil
+}
+
+func (c *Config) parseWriterMatrix(args []string) error {
+	var homeserver string
+	var token string
+	var rooms []string
+	var t *template.Template
+	var err error
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "homeserver=") {
+			homeserver = strings.TrimPrefix(arg, "homeserver=")
+		} else if strings.HasPrefix(arg, "token=") {
+			token = strings.TrimPrefix(arg, "token=")
+		} else if strings.HasPrefix(arg, "room=") {
+			rooms = append(rooms, strings.TrimPrefix(arg, "room="))
+		} else if strings.HasPrefix(arg, "template=") {
+			t, err = tmpl.ParseFile(strings.TrimPrefix(arg, "template="))
+			if err != nil {
+				return err
+			}
+		} else if strings.HasPrefix(arg, "template_text=") {
+			t, err = tmpl.Parse("template_text", strings.TrimPrefix(arg, "template_text="))
+			if err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("unknown matrix option: %q", arg)
+		}
+	}
+
+	if homeserver == "" {
+		return fmt.Errorf("writer matrix requires a homeserver option")
+	}
+
+	if token == "" {
+		return fmt.Errorf("writer matrix requires a token option")
+	}
+
+	if len(rooms) == 0 {
+		return fmt.Errorf("writer matrix requires at least one room option")
+	}
+
+	c.Writers = append(c.Writers, matrix.New(homeserver, token, rooms, t))
+	return nil
+}
+
+func (c *Config) parseWriterIRC(args []string) error {
+	var opts irc.Options
+	var err error
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "server=") {
+			opts.Address = strings.TrimPrefix(arg, "server=")
+		} else if strings.HasPrefix(arg, "tls=") {
+			v := strings.TrimPrefix(arg, "tls=")
+			opts.TLS, err = strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid tls value %q: %w", v, err)
+			}
+		} else if strings.HasPrefix(arg, "nick=") {
+			opts.Nick = strings.TrimPrefix(arg, "nick=")
+		} else if strings.HasPrefix(arg, "user=") {
+			opts.User = strings.TrimPrefix(arg, "user=")
+		} else if strings.HasPrefix(arg, "realname=") {
+			opts.RealName = strings.TrimPrefix(arg, "realname=")
+		} else if strings.HasPrefix(arg, "password=") {
+			opts.Password = strings.TrimPrefix(arg, "password=")
+		} else if strings.HasPrefix(arg, "channel=") {
+			opts.Channels = append(opts.Channels, strings.TrimPrefix(arg, "channel="))
+		} else if strings.HasPrefix(arg, "rate=") {
+			v := strings.TrimPrefix(arg, "rate=")
+			opts.Rate, err = time.ParseDuration(v)
+			if err != nil || opts.Rate <= 0 {
+				return fmt.Errorf("invalid rate %q, expected a positive duration", v)
+			}
+		} else if strings.HasPrefix(arg, "burst=") {
+			v := strings.TrimPrefix(arg, "burst=")
+			opts.Burst, err = strconv.Atoi(v)
+			if err != nil || opts.Burst <= 0 {
+				return fmt.Errorf("invalid burst %q, expected a positive number", v)
+			}
+		} else {
+			return fmt.Errorf("unknown irc option: %q", arg)
+		}
+	}
+
+	if opts.Address == "" {
+		return fmt.Errorf("writer irc requires a server option")
+	}
+
+	if _, _, err := net.SplitHostPort(opts.Address); err != nil {
+		return fmt.Errorf("invalid irc server %q: %w", opts.Address, err)
+	}
+
+	if opts.Nick == "" {
+		return fmt.Errorf("writer irc requires a nick option")
+	}
+
+	if len(opts.Channels) == 0 {
+		return fmt.Errorf("writer irc requires at least one channel option")
+	}
+
+	c.Writers = append(c.Writers, irc.New(opts))
+	return nil
+}
+
+func (c *Config) parseWriterMQTT(args []string) error {
+	var opts mqtt.Options
+	var err error
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "broker=") {
+			opts.Address = strings.TrimPrefix(arg, "broker=")
+		} else if strings.HasPrefix(arg, "tls=") {
+			v := strings.TrimPrefix(arg, "tls=")
+			opts.TLS, err = strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid tls value %q: %w", v, err)
+			}
+		} else if strings.HasPrefix(arg, "client_id=") {
+			opts.ClientID = strings.TrimPrefix(arg, "client_id=")
+		} else if strings.HasPrefix(arg, "username=") {
+			opts.Username = strings.TrimPrefix(arg, "username=")
+		} else if strings.HasPrefix(arg, "password=") {
+			opts.Password = strings.TrimPrefix(arg, "password=")
+		} else if strings.HasPrefix(arg, "topic=") {
+			opts.Topic, err = tmpl.Parse("topic", strings.TrimPrefix(arg, "topic="))
+			if err != nil {
+				return err
+			}
+		} else if strings.HasPrefix(arg, "qos=") {
+			v := strings.TrimPrefix(arg, "qos=")
+			qos, err := strconv.ParseUint(v, 10, 8)
+			if err != nil || qos > 2 {
+				return fmt.Errorf("invalid qos %q, must be 0, 1 or 2", v)
+			}
+			opts.QoS = byte(qos)
+		} else if strings.HasPrefix(arg, "retain=") {
+			v := strings.TrimPrefix(arg, "retain=")
+			opts.Retain, err = strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid retain value %q: %w", v, err)
+			}
+		} else if strings.HasPrefix(arg, "keepalive=") {
+			v := strings.TrimPrefix(arg, "keepalive=")
+			opts.KeepAlive, err = time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid keepalive %q: %w", v, err)
+			}
+		} else {
+			return fmt.Errorf("unknown mqtt option: %q", arg)
+		}
+	}
+
+	if opts.Address == "" {
+		return fmt.Errorf("writer mqtt requires a broker option")
+	}
+
+	if _, _, err := net.SplitHostPort(opts.Address); err != nil {
+		return fmt.Errorf("invalid mqtt broker %q: %w", opts.Address, err)
+	}
+
+	w, err := mqtt.New(opts)
+	if err != nil {
+		return err
+	}
+
+	c.Writers = append(c.Writers, w)
+	return nil
+}
+
+func (c *Config) parseWriterRedis(args []string) error {
+	var opts redis.Options
+	var err error
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "address=") {
+			opts.Address = strings.TrimPrefix(arg, "address=")
+		} else if strings.HasPrefix(arg, "tls=") {
+			v := strings.TrimPrefix(arg, "tls=")
+			opts.TLS, err = strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid tls value %q: %w", v, err)
+			}
+		} else if strings.HasPrefix(arg, "username=") {
+			opts.Username = strings.TrimPrefix(arg, "username=")
+		} else if strings.HasPrefix(arg, "password=") {
+			opts.Password = strings.TrimPrefix(arg, "password=")
+		} else if strings.HasPrefix(arg, "db=") {
+			v := strings.TrimPrefix(arg, "db=")
+			opts.DB, err = strconv.Atoi(v)
+			if err != nil || opts.DB < 0 {
+				return fmt.Errorf("invalid db %q", v)
+			}
+		} else if strings.HasPrefix(arg, "channel=") {
+			opts.Channel = strings.TrimPrefix(arg, "channel=")
+		} else if strings.HasPrefix(arg, "stream=") {
+			opts.Stream = strings.TrimPrefix(arg, "stream=")
+		} else if strings.HasPrefix(arg, "maxlen=") {
+			v := strings.TrimPrefix(arg, "maxlen=")
+			opts.MaxLen, err = strconv.Atoi(v)
+			if err != nil || opts.MaxLen < 0 {
+				return fmt.Errorf("invalid maxlen %q", v)
+			}
+		} else {
+			return fmt.Errorf("unknown redis option: %q", arg)
+		}
+	}
+
+	if opts.Address == "" {
+		return fmt.Errorf("writer redis requires an address option")
+	}
+
+	if _, _, err := net.SplitHostPort(opts.Address); err != nil {
+		return fmt.Errorf("invalid redis address %q: %w", opts.Address, err)
+	}
+
+	if (opts.Channel == "") == (opts.Stream == "") {
+		return fmt.Errorf("writer redis requires exactly one of the channel and stream options")
+	}
+
+	if opts.MaxLen != 0 && opts.Stream == "" {
+		return fmt.Errorf("redis maxlen option requires the stream option")
+	}
+
+	c.Writers = append(c.Writers, redis.New(opts))
+	return nil
+}
+
+func (c *Config) parseWriterNATS(args []string) error {
+	var opts nats.Options
+	var err error
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "address=") {
+			opts.Address = strings.TrimPrefix(arg, "address=")
+		} else if strings.HasPrefix(arg, "tls=") {
+			v := strings.TrimPrefix(arg, "tls=")
+			opts.TLS, err = strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid tls value %q: %w", v, err)
+			}
+		} else if strings.HasPrefix(arg, "username=") {
+			opts.Username = strings.TrimPrefix(arg, "username=")
+		} else if strings.HasPrefix(arg, "password=") {
+			opts.Password = strings.TrimPrefix(arg, "password=")
+		} else if strings.HasPrefix(arg, "token=") {
+			opts.Token = strings.TrimPrefix(arg, "token=")
+		} else if strings.HasPrefix(arg, "subject=") {
+			opts.Subject = strings.TrimPrefix(arg, "subject=")
+		} else {
+			return fmt.Errorf("unknown nats option: %q", arg)
+		}
+	}
+
+	if opts.Address == "" {
+		return fmt.Errorf("writer nats requires an address option")
+	}
+
+	if _, _, err := net.SplitHostPort(opts.Address); err != nil {
+		return fmt.Errorf("invalid nats address %q: %w", opts.Address, err)
+	}
+
+	if opts.Subject == "" {
+		return fmt.Errorf("writer nats requires a subject option")
+	}
+
+	if strings.ContainsAny(opts.Subject, "*> \t") {
+		return fmt.Errorf("invalid nats subject %q", opts.Subject)
+	}
+
+	c.Writers = append(c.Writers, nats.New(opts))
+	return nil
+}
+
+func (c *Config) parseWriterSQL(args []string) error {
+	var opts database.Options
+	var err error
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "driver=") {
+			opts.Driver = strings.TrimPrefix(arg, "driver=")
+		} else if strings.HasPrefix(arg, "dsn=") {
+			opts.DSN = strings.TrimPrefix(arg, "dsn=")
+		} else if strings.HasPrefix(arg, "batch_size=") {
+			v := strings.TrimPrefix(arg, "batch_size=")
+			opts.BatchSize, err = strconv.Atoi(v)
+			if err != nil || opts.BatchSize <= 0 {
+				return fmt.Errorf("invalid batch_size %q", v)
+			}
+		} else if strings.HasPrefix(arg, "flush_interval=") {
+			v := strings.TrimPrefix(arg, "flush_interval=")
+			opts.FlushInterval, err = time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid flush_interval %q: %w", v, err)
+			}
+		} else {
+			return fmt.Errorf("unknown sql option: %q", arg)
+		}
+	}
+
+	if opts.Driver != "sqlite" && opts.Driver != "postgres" {
+		return fmt.Errorf("sql driver must be either sqlite or postgres")
+	}
+
+	if opts.DSN == "" {
+		return fmt.Errorf("writer sql requires a dsn option")
+	}
+
+	w, err := database.New(opts)
+	if err != nil {
+		return err
+	}
+
+	c.Writers = append(c.Writers, w)
+	return nil
+}
+
+func (c *Config) parseWriterLive(args []string) error {
+	var opts live.Options
+	var err error
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "address=") {
+			opts.Address = strings.TrimPrefix(arg, "address=")
+		} else if strings.HasPrefix(arg, "buffer=") {
+			v := strings.TrimPrefix(arg, "buffer=")
+			opts.Buffer, err = strconv.Atoi(v)
+			if err != nil || opts.Buffer <= 0 {
+				return fmt.Errorf("invalid buffer %q", v)
+			}
+		} else if strings.HasPrefix(arg, "replay=") {
+			v := strings.TrimPrefix(arg, "replay=")
+			opts.Replay, err = strconv.Atoi(v)
+			if err != nil || opts.Replay < 0 {
+				return fmt.Errorf("invalid replay %q", v)
+			}
+		} else if strings.HasPrefix(arg, "origin=") {
+			v := strings.TrimPrefix(arg, "origin=")
+			if v != "*" {
+				if u, err := url.Parse(v); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+					return fmt.Errorf("invalid live origin %q, must be a scheme and host or *", v)
+				}
+			}
+			opts.Origins = append(opts.Origins, v)
+		} else {
+			return fmt.Errorf("unknown live option: %q", arg)
+		}
+	}
+
+	if opts.Address != "" {
+		if _, _, err := net.SplitHostPort(opts.Address); err != nil {
+			return fmt.Errorf("invalid live address %q: %w", opts.Address, err)
+		}
+	}
+
+	c.Writers = append(c.Writers, live.New(opts))
 	return nil
 }
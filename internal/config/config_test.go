@@ -0,0 +1,109 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDebugOverride(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qwbs.conf")
+	text := "listen_address 127.0.0.1:27500\nmaster_address 127.0.0.1:27000\nwriter slogger\nlog_level warn master=error\n"
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		overrides []Override
+		want      slog.Level
+	}{
+		{
+			name: "file",
+			want: slog.LevelWarn,
+		},
+		{
+			name:      "debug",
+			overrides: []Override{NewOverride("flag -debug", "debug", "true")},
+			want:      slog.LevelDebug,
+		},
+		{
+			name:      "debug disabled",
+			overrides: []Override{NewOverride("QWBS_DEBUG", "debug", "false")},
+			want:      slog.LevelInfo,
+		},
+		{
+			name: "debug and log_level",
+			overrides: []Override{
+				NewOverride("flag -debug", "debug", "true"),
+				NewOverride("QWBS_LOG_LEVEL", "log_level", "error"),
+			},
+			want: slog.LevelError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := Load(path, tt.overrides)
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if got := conf.Logging.Levels[""]; got != tt.want {
+				t.Errorf("got default level %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadLive(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{
+			name: "own address",
+			text: "writer live address=127.0.0.1:8080 origin=https://qw.example.com",
+		},
+		{
+			name: "http_address",
+			text: "http_address 127.0.0.1:8000\nwriter live origin=*",
+		},
+		{
+			name:    "no address",
+			text:    "writer live",
+			wantErr: "writer live requires an address option or http_address",
+		},
+		{
+			name:    "twice on http_address",
+			text:    "http_address 127.0.0.1:8000\nwriter live\nwriter live replay=10",
+			wantErr: "only one writer live can be served on http_address",
+		},
+		{
+			name:    "invalid origin",
+			text:    "writer live address=127.0.0.1:8080 origin=qw.example.com",
+			wantErr: "must be a scheme and host",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "qwbs.conf")
+			text := "listen_address 127.0.0.1:27500\nmaster_address 127.0.0.1:27000\n" + tt.text + "\n"
+			if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := Load(path, nil)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
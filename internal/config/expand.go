@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// refPattern matches ${NAME} environment variable references and ${file:PATH}
+// secret file references.
+var refPattern = regexp.MustCompile(`\$\{(?:([A-Za-z_][A-Za-z0-9_]*)|file:([^}]+))\}`)
+
+// expandArgs expands environment variable and secret file references in the
+// arguments. ${NAME} is replaced by the value of the environment variable and
+// ${file:PATH} by the contents of the file. Single quoted parts of the
+// arguments are left alone. The values are not expanded again, so they can
+// contain anything.
+func expandArgs(args []field) ([]string, error) {
+	expanded := make([]string, len(args))
+
+	for i, arg := range args {
+		var b strings.Builder
+
+		for _, part := range arg.parts {
+			if part.literal {
+				b.WriteString(part.text)
+				continue
+			}
+
+			s, err := expand(part.text)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteString(s)
+		}
+
+		expanded[i] = b.String()
+	}
+
+	return expanded, nil
+}
+
+func expand(s string) (string, error) {
+	var err error
+
+	s = refPattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := refPattern.FindStringSubmatch(m)
+
+		if path := sub[2]; path != "" {
+			v, readErr := readSecret(path)
+			if readErr != nil && err == nil {
+				err = readErr
+			}
+			return v
+		}
+
+		v, ok := os.LookupEnv(sub[1])
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %q is not set", sub[1])
+		}
+		return v
+	})
+
+	return s, err
+}
+
+// readSecret reads a secret from a file, such as a Docker or Kubernetes
+// secret. A trailing newline is removed since most editors add one.
+func readSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %w", err)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExpandArgs(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("QWBS_TEST_HOST", "localhost")
+	t.Setenv("QWBS_TEST_REF", "${file:"+secret+"}")
+
+	tests := []struct {
+		name string
+		line string
+		want []string
+		err  string
+	}{
+		{
+			name: "plain",
+			line: "poster format=discord",
+			want: []string{"poster", "format=discord"},
+		},
+		{
+			name: "env",
+			line: "url=http://${QWBS_TEST_HOST}:4554/${QWBS_TEST_HOST}",
+			want: []string{"url=http://localhost:4554/localhost"},
+		},
+		{
+			name: "unset env",
+			line: "url=${QWBS_TEST_UNSET}",
+			err:  `environment variable "QWBS_TEST_UNSET" is not set`,
+		},
+		{
+			name: "file",
+			line: "password=${file:" + secret + "} ${file:" + secret + "}",
+			want: []string{"password=s3cr3t", "s3cr3t"},
+		},
+		{
+			name: "missing file",
+			line: "password=${file:" + filepath.Join(dir, "missing") + "}",
+			err:  "failed to read secret",
+		},
+		{
+			name: "sqlite uri",
+			line: "dsn=file:/var/lib/qwbs/qwbs.db?cache=shared",
+			want: []string{"dsn=file:/var/lib/qwbs/qwbs.db?cache=shared"},
+		},
+		{
+			name: "values are not expanded again",
+			line: "${QWBS_TEST_REF}",
+			want: []string{"${file:" + secret + "}"},
+		},
+		{
+			name: "unterminated reference",
+			line: "pa${ss ${file:",
+			want: []string{"pa${ss", "${file:"},
+		},
+		{
+			name: "double quoted",
+			line: `username="${QWBS_TEST_HOST} bot"`,
+			want: []string{"username=localhost bot"},
+		},
+		{
+			name: "single quoted",
+			line: `password='${QWBS_TEST_UNSET}' url='${QWBS_TEST_HOST}'/${QWBS_TEST_HOST}`,
+			want: []string{"password=${QWBS_TEST_UNSET}", "url=${QWBS_TEST_HOST}/localhost"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandArgs(splitFields(tt.line))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadInclude(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "conf.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write("conf.d/10-master.conf", "master_address 127.0.0.1:27000\n")
+	write("conf.d/20-writer.conf", "writer slogger format=json\n")
+	path := write("qwbs.conf", "listen_address 127.0.0.1:27500\ninclude conf.d/*.conf\n")
+
+	conf, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if len(conf.MasterAddresses) != 1 || conf.MasterAddresses[0].String() != "127.0.0.1:27000" {
+		t.Errorf("got masters %v, want the included master", conf.MasterAddresses)
+	}
+
+	if !slices.Equal(conf.WriterSpecs, []string{"slogger format=json"}) {
+		t.Errorf("got writers %q, want the included writer", conf.WriterSpecs)
+	}
+}
+
+func TestLoadIncludeRecursive(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.conf")
+	b := filepath.Join(dir, "b.conf")
+
+	if err := os.WriteFile(a, []byte("listen_address 127.0.0.1:27500\ninclude b.conf\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("include a.conf\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(a, nil)
+	if err == nil || !strings.Contains(err.Error(), "included recursively") {
+		t.Fatalf("got error %v, want a recursive include error", err)
+	}
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// logicalLine is a config line with continuations joined, num is the number
+// of the line it starts on.
+type logicalLine struct {
+	num  int
+	text string
+}
+
+// readLines reads the logical lines from r, skipping blank lines and
+// comments. A line ending with a backslash preceded by whitespace is
+// continued on the next line, a backslash at the end of a value is kept.
+func readLines(r io.Reader) ([]logicalLine, error) {
+	var lines []logicalLine
+	var cur *logicalLine
+
+	scanner := bufio.NewScanner(r)
+	num := 0
+
+	for scanner.Scan() {
+		num++
+		line := strings.TrimSpace(scanner.Text())
+
+		if cur == nil {
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			cur = &logicalLine{num: num}
+		}
+
+		text, continued := strings.CutSuffix(line, `\`)
+		if continued && text != "" && !strings.HasSuffix(text, " ") && !strings.HasSuffix(text, "\t") {
+			text, continued = line, false
+		}
+		text = strings.TrimSpace(text)
+
+		if cur.text != "" && text != "" {
+			cur.text += " "
+		}
+		cur.text += text
+
+		if !continued {
+			lines = append(lines, *cur)
+			cur = nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if cur != nil {
+		lines = append(lines, *cur)
+	}
+
+	return lines, nil
+}
+
+// field is a field of a config line. The parts of a field that were single
+// quoted are literal and aren't expanded.
+type field struct {
+	parts  []fieldPart
+	quoted bool
+}
+
+type fieldPart struct {
+	text    string
+	literal bool
+}
+
+// plainField returns a field without literal parts, e.g. a value of a
+// structured config.
+func plainField(s string) field {
+	return field{parts: []fieldPart{{text: s}}}
+}
+
+func (f field) String() string {
+	var b strings.Builder
+	for _, part := range f.parts {
+		b.WriteString(part.text)
+	}
+
+	return b.String()
+}
+
+// is reports whether the field is the unquoted s, such as the braces of a
+// block, so that quoting a field escapes the syntax.
+func (f field) is(s string) bool {
+	return !f.quoted && f.String() == s
+}
+
+// fieldStrings returns the text of the fields without expanding them.
+func fieldStrings(fields []field) []string {
+	s := make([]string, len(fields))
+	for i, f := range fields {
+		s[i] = f.String()
+	}
+
+	return s
+}
+
+// splitFields splits a line into fields separated by whitespace. A quote at
+// the start of a field, or of the value of a key=value field, starts a quoted
+// string, e.g. username="QW Bot". Double quoted strings may contain the
+// escapes \", \\, \n and \t, single quoted strings are taken literally.
+// Other quotes and unterminated quoted strings are kept as is, as are
+// backslashes outside of quotes, for compatibility with existing configs.
+func splitFields(line string) []field {
+	var fields []field
+	var cur field
+	var b strings.Builder
+	inField := false
+	inValue := false
+	quotable := true
+
+	flush := func() {
+		if b.Len() > 0 {
+			cur.parts = append(cur.parts, fieldPart{text: b.String()})
+			b.Reset()
+		}
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		canQuote := quotable
+		quotable = false
+
+		switch {
+		case c == ' ' || c == '\t':
+			if inField {
+				flush()
+				fields = append(fields, cur)
+				cur = field{}
+				inField = false
+				inValue = false
+			}
+			quotable = true
+		case c == '"' && canQuote:
+			inField = true
+			if end, ok := readDoubleQuoted(line, i+1, &b); ok {
+				cur.quoted = true
+				i = end
+			} else {
+				b.WriteByte(c)
+			}
+		case c == '\'' && canQuote:
+			inField = true
+			if end := strings.IndexByte(line[i+1:], '\''); end != -1 {
+				flush()
+				cur.parts = append(cur.parts, fieldPart{text: line[i+1 : i+1+end], literal: true})
+				cur.quoted = true
+				i += end + 1
+			} else {
+				b.WriteByte(c)
+			}
+		default:
+			if c == '=' && !inValue {
+				inValue = true
+				quotable = true
+			}
+			inField = true
+			b.WriteByte(c)
+		}
+	}
+
+	if inField {
+		flush()
+		fields = append(fields, cur)
+	}
+
+	return fields
+}
+
+// readDoubleQuoted writes the contents of the double quoted string starting
+// at i to b and returns the index of the closing quote. Nothing is written if
+// the string is unterminated. Unknown escapes are kept as is.
+func readDoubleQuoted(line string, i int, b *strings.Builder) (int, bool) {
+	var s strings.Builder
+
+	for ; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case c == '"':
+			b.WriteString(s.String())
+			return i, true
+		case c == '\\' && i+1 < len(line):
+			i++
+
+			switch line[i] {
+			case 'n':
+				s.WriteByte('\n')
+			case 't':
+				s.WriteByte('\t')
+			case '"', '\\':
+				s.WriteByte(line[i])
+			default:
+				s.WriteByte('\\')
+				s.WriteByte(line[i])
+			}
+		default:
+			s.WriteByte(c)
+		}
+	}
+
+	return 0, false
+}
+
+// blockArgs converts the fields of a line within a block to arguments. Each
+// line holds key=value options, where key = value is also accepted.
+func blockArgs(fields []field) []field {
+	if len(fields) == 3 && fields[1].is("=") {
+		f := field{quoted: fields[0].quoted || fields[2].quoted}
+		f.parts = append(f.parts, fields[0].parts...)
+		f.parts = append(f.parts, fieldPart{text: "="})
+		f.parts = append(f.parts, fields[2].parts...)
+		return []field{f}
+	}
+
+	return fields
+}
+
+// readBlock reads the block that starts on lines[start] and returns the
+// fields of the option with the block arguments appended, along with the
+// index of the closing line. The block ends on the first line with a single
+// unquoted }, blocks can't be nested.
+func readBlock(lines []logicalLine, start int, fields []field) ([]field, int, error) {
+	if len(fields) == 0 {
+		return nil, start, fmt.Errorf("block without an option")
+	}
+
+	for i := start + 1; i < len(lines); i++ {
+		blockFields := splitFields(lines[i].text)
+
+		if len(blockFields) == 1 && blockFields[0].is("}") {
+			return fields, i, nil
+		}
+
+		fields = append(fields, blockArgs(blockFields)...)
+	}
+
+	return nil, len(lines) - 1, fmt.Errorf("unterminated block")
+}
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitFields(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{
+			name: "plain",
+			line: "writer slogger\tformat=text  output=stderr",
+			want: []string{"writer", "slogger", "format=text", "output=stderr"},
+		},
+		{
+			name: "double quoted value",
+			line: `writer poster username="QW Bot" url=http://localhost`,
+			want: []string{"writer", "poster", "username=QW Bot", "url=http://localhost"},
+		},
+		{
+			name: "single quoted value",
+			line: `writer poster username='QW "Bot"'`,
+			want: []string{"writer", "poster", `username=QW "Bot"`},
+		},
+		{
+			name: "quoted field",
+			line: `log_output "/var/log/qw bs.log"`,
+			want: []string{"log_output", "/var/log/qw bs.log"},
+		},
+		{
+			name: "escapes",
+			line: `template_text="{{.Broadcast.Name}}:\t\"{{.Broadcast.Message}}\"\n\\"`,
+			want: []string{"template_text={{.Broadcast.Name}}:\t\"{{.Broadcast.Message}}\"\n\\"},
+		},
+		{
+			name: "unknown escape",
+			line: `password="pa\ss"`,
+			want: []string{`password=pa\ss`},
+		},
+		{
+			name: "quote within value",
+			line: `writer irc username=Bob's password=pa"ss`,
+			want: []string{"writer", "irc", "username=Bob's", `password=pa"ss`},
+		},
+		{
+			name: "quote after second equals sign",
+			line: `url=http://localhost/?q="a b"`,
+			want: []string{`url=http://localhost/?q="a`, `b"`},
+		},
+		{
+			name: "unterminated quote",
+			line: `password="secret username='qwbs`,
+			want: []string{`password="secret`, "username='qwbs"},
+		},
+		{
+			name: "backslashes",
+			line: `path=C:\qwbs\ password=secret\`,
+			want: []string{`path=C:\qwbs\`, `password=secret\`},
+		},
+		{
+			name: "quotes inside values",
+			line: `template_text="say \"hi\"" name='a"b' topic=qw/"x"`,
+			want: []string{`template_text=say "hi"`, `name=a"b`, `topic=qw/"x"`},
+		},
+		{
+			name: "quoted braces",
+			line: `writer poster "{" '}' {`,
+			want: []string{"writer", "poster", "{", "}", "{"},
+		},
+		{
+			name: "empty quotes",
+			line: `username="" avatar_url=''`,
+			want: []string{"username=", "avatar_url="},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fieldStrings(splitFields(tt.line)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	text := strings.Join([]string{
+		"# comment \\",
+		"",
+		"writer poster \\",
+		"  format=discord \\",
+		"  url=http://localhost",
+		"password=secret\\",
+		"master_address 127.0.0.1:27000\t\\",
+		"\\",
+		"  127.0.0.1:27001 \\",
+	}, "\n")
+
+	lines, err := readLines(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	want := []logicalLine{
+		{num: 3, text: "writer poster format=discord url=http://localhost"},
+		{num: 6, text: `password=secret\`},
+		{num: 7, text: "master_address 127.0.0.1:27000 127.0.0.1:27001"},
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("got %+v, want %+v", lines, want)
+	}
+}
+
+func TestReadOptionsBlock(t *testing.T) {
+	text := `writer poster {
+	format = discord
+	url=http://localhost
+	username = "QW Bot"
+}
+writer slogger format=json {
+}
+writer mqtt {
+	broker = localhost:1883
+`
+
+	options, err := readOptions("qwbs.conf", strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if len(options) != 3 {
+		t.Fatalf("got %d options, want 3", len(options))
+	}
+
+	want := []string{"writer", "poster", "format=discord", "url=http://localhost", "username=QW Bot"}
+	if options[0].err != nil || !slices.Equal(fieldStrings(options[0].fields), want) {
+		t.Errorf("got %q, %v, want %q", fieldStrings(options[0].fields), options[0].err, want)
+	}
+
+	want = []string{"writer", "slogger", "format=json"}
+	if options[1].err != nil || !slices.Equal(fieldStrings(options[1].fields), want) {
+		t.Errorf("got %q, %v, want %q", fieldStrings(options[1].fields), options[1].err, want)
+	}
+
+	if options[2].err == nil || !strings.Contains(options[2].err.Error(), "unterminated block") {
+		t.Errorf("got error %v, want an unterminated block error", options[2].err)
+	}
+}
+
+// TestSplitFieldsExample checks that the options in the example config,
+// including the commented out ones, are split the same way as before quoting
+// was supported.
+func TestSplitFieldsExample(t *testing.T) {
+	data, err := os.ReadFile("../../qwbs.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimPrefix(line, "# ")
+		name, _, _ := strings.Cut(line, " ")
+		if !strings.Contains(name, "_") && name != "writer" && name != "debug" && name != "include" && name != "tracing" {
+			continue
+		}
+		if strings.ContainsAny(name, ".:,\"") {
+			continue
+		}
+
+		n++
+		if got, want := fieldStrings(splitFields(line)), strings.Fields(line); !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	if n < 40 {
+		t.Errorf("got %d options, want the options of the example config", n)
+	}
+
+	if _, err := Load("../../qwbs.conf", nil); err != nil {
+		t.Errorf("got error %v loading the example config", err)
+	}
+}
+
+func TestReadOptionsQuotedBraces(t *testing.T) {
+	text := `writer slogger template_text="{"
+writer poster {
+	template_text = "{{.Broadcast.Name}} }"
+	"}"
+	url = '{'
+}
+writer mqtt {
+	writer slogger {
+	}
+}
+`
+
+	options, err := readOptions("qwbs.conf", strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if len(options) != 4 {
+		t.Fatalf("got %d options, want 4", len(options))
+	}
+
+	// Quoted braces are values rather than the start or end of a block.
+	want := []string{"writer", "slogger", "template_text={"}
+	if options[0].err != nil || !slices.Equal(fieldStrings(options[0].fields), want) {
+		t.Errorf("got %q, %v, want %q", fieldStrings(options[0].fields), options[0].err, want)
+	}
+
+	want = []string{"writer", "poster", "template_text={{.Broadcast.Name}} }", "}", "url={"}
+	if options[1].err != nil || !slices.Equal(fieldStrings(options[1].fields), want) {
+		t.Errorf("got %q, %v, want %q", fieldStrings(options[1].fields), options[1].err, want)
+	}
+
+	// Blocks aren't nested, the first } ends the block and the second one
+	// is left as an option of its own.
+	want = []string{"writer", "mqtt", "writer", "slogger", "{"}
+	if options[2].err != nil || !slices.Equal(fieldStrings(options[2].fields), want) {
+		t.Errorf("got %q, %v, want %q", fieldStrings(options[2].fields), options[2].err, want)
+	}
+
+	want = []string{"}"}
+	if options[3].err != nil || !slices.Equal(fieldStrings(options[3].fields), want) {
+		t.Errorf("got %q, %v, want %q", fieldStrings(options[3].fields), options[3].err, want)
+	}
+}
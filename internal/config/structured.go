@@ -0,0 +1,479 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatTOML = "toml"
+	FormatYAML = "yaml"
+)
+
+// structuredConfig is the layout of YAML and TOML config files. The options
+// are the same as in the line based format, writers and tracing take their
+// options as keys where lists and maps expand to repeated options.
+type structuredConfig struct {
+	Include       []string          `yaml:"include,omitempty" toml:"include,omitempty"`
+	Debug         *bool             `yaml:"debug,omitempty" toml:"debug,omitempty"`
+	ListenAddress string            `yaml:"listen_address,omitempty" toml:"listen_address,omitempty"`
+	HTTPAddress   string            `yaml:"http_address,omitempty" toml:"http_address,omitempty"`
+	HistorySize   int               `yaml:"history_size,omitempty" toml:"history_size,omitzero"`
+	LogFormat     string            `yaml:"log_format,omitempty" toml:"log_format,omitempty"`
+	LogLevel      map[string]string `yaml:"log_level,omitempty" toml:"log_level,omitempty"`
+	LogOutput     string            `yaml:"log_output,omitempty" toml:"log_output,omitempty"`
+	LogSampling   map[string]any    `yaml:"log_sampling,omitempty" toml:"log_sampling,omitempty"`
+	Masters       []any             `yaml:"masters,omitempty" toml:"masters,omitempty"`
+	Tracing       map[string]any    `yaml:"tracing,omitempty" toml:"tracing,omitempty"`
+	Writers       []map[string]any  `yaml:"writers,omitempty" toml:"writers,omitempty"`
+}
+
+func structuredFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return ""
+	}
+}
+
+func (p *parser) parseStructuredFile(path, format string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var sc structuredConfig
+	if format == FormatTOML {
+		dec := toml.NewDecoder(bytes.NewReader(b))
+		md, err := dec.Decode(&sc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		// Only top level keys can be unknown, the keys of nested tables
+		// such as master settings are reported as undecoded when they're
+		// decoded into an interface.
+		for _, key := range md.Undecoded() {
+			if len(key) == 1 {
+				return fmt.Errorf("%s: unknown config option: %q", path, key.String())
+			}
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&sc); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	options, errs := sc.options()
+	for _, err := range errs {
+		p.errs = append(p.errs, fmt.Errorf("%s: %w", path, err))
+	}
+
+	for _, o := range options {
+		loc := path + ": " + o.where
+		fields := make([]field, len(o.fields))
+		for i, s := range o.fields {
+			fields[i] = plainField(s)
+		}
+
+		if err := p.apply(path, loc, fields); err != nil {
+			p.errs = append(p.errs, fmt.Errorf("%s: %w", loc, err))
+		}
+	}
+
+	return nil
+}
+
+type structuredOption struct {
+	where  string
+	fields []string
+}
+
+// options converts the structured config to the options of the line based
+// format, so that both are parsed and validated the same way. Errors are
+// returned per entry so that the valid entries can still be parsed.
+func (sc *structuredConfig) options() ([]structuredOption, []error) {
+	var options []structuredOption
+	var errs []error
+	add := func(where string, fields ...string) {
+		options = append(options, structuredOption{where: where, fields: fields})
+	}
+	addArgs := func(where, opt string, prefix []string, m map[string]any) {
+		args, err := structuredArgs(m)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", where, err))
+			return
+		}
+		add(where, append(append([]string{opt}, prefix...), args...)...)
+	}
+
+	if len(sc.Include) > 0 {
+		add("include", append([]string{"include"}, sc.Include...)...)
+	}
+
+	if sc.Debug != nil {
+		add("debug", "debug", strconv.FormatBool(*sc.Debug))
+	}
+
+	if sc.ListenAddress != "" {
+		add("listen_address", "listen_address", sc.ListenAddress)
+	}
+
+	if sc.HTTPAddress != "" {
+		add("http_address", "http_address", sc.HTTPAddress)
+	}
+
+	if sc.HistorySize != 0 {
+		add("history_size", "history_size", strconv.Itoa(sc.HistorySize))
+	}
+
+	if sc.LogFormat != "" {
+		add("log_format", "log_format", sc.LogFormat)
+	}
+
+	if sc.LogLevel != nil {
+		levels := make(map[string]any, len(sc.LogLevel))
+		for k, v := range sc.LogLevel {
+			levels[k] = v
+		}
+
+		addArgs("log_level", "log_level", nil, levels)
+	}
+
+	if sc.LogOutput != "" {
+		add("log_output", "log_output", sc.LogOutput)
+	}
+
+	if sc.LogSampling != nil {
+		addArgs("log_sampling", "log_sampling", nil, sc.LogSampling)
+	}
+
+	for i, m := range sc.Masters {
+		where := fmt.Sprintf("masters[%d]", i)
+
+		// Masters are either given as an address or as a table with an
+		// address key and the master settings.
+		switch v := m.(type) {
+		case string:
+			add(where, "master_address", v)
+		case map[string]any:
+			addr, ok := v["address"].(string)
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: missing master address", where))
+				continue
+			}
+			addArgs(where, "master_address", []string{addr}, without(v, "address"))
+		default:
+			errs = append(errs, fmt.Errorf("%s: expected an address or a table", where))
+		}
+	}
+
+	if sc.Tracing != nil {
+		addArgs("tracing", "tracing", nil, sc.Tracing)
+	}
+
+	for i, w := range sc.Writers {
+		where := fmt.Sprintf("writers[%d]", i)
+
+		typ, ok := w["type"].(string)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: missing writer type", where))
+			continue
+		}
+		addArgs(where, "writer", []string{typ}, without(w, "type"))
+	}
+
+	return options, errs
+}
+
+// without returns a copy of m without key.
+func without(m map[string]any, key string) map[string]any {
+	c := make(map[string]any, len(m))
+	for k, v := range m {
+		if k != key {
+			c[k] = v
+		}
+	}
+
+	return c
+}
+
+// structuredArgs converts a table of options to key=value arguments sorted
+// by key. Lists become repeated options and tables become repeated options
+// of the form key=name:value, as used by e.g. the header and filter options,
+// where a list of values repeats the option for each value.
+func structuredArgs(m map[string]any) ([]string, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case []any:
+			for _, item := range v {
+				s, err := scalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", k, err)
+				}
+				args = append(args, k+"="+s)
+			}
+		case map[string]any:
+			names := make([]string, 0, len(v))
+			for name := range v {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				values, ok := v[name].([]any)
+				if !ok {
+					values = []any{v[name]}
+				}
+
+				for _, item := range values {
+					s, err := scalar(item)
+					if err != nil {
+						return nil, fmt.Errorf("%s.%s: %w", k, name, err)
+					}
+					args = append(args, k+"="+name+":"+s)
+				}
+			}
+		default:
+			s, err := scalar(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			args = append(args, k+"="+s)
+		}
+	}
+
+	return args, nil
+}
+
+func scalar(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// Convert reads a line based config file and returns it in the given
+// structured format. Environment variables and secret file references are
+// kept as is, comments are not preserved.
+func Convert(path, format string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	options, err := readOptions(path, file)
+	if err != nil {
+		return nil, err
+	}
+
+	var sc structuredConfig
+	for _, o := range options {
+		if o.err != nil {
+			return nil, o.err
+		}
+
+		if err := sc.add(fieldStrings(o.fields)); err != nil {
+			return nil, &LineError{Path: path, Line: o.line, Err: err}
+		}
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case FormatYAML:
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&sc); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		if err := toml.NewEncoder(&buf).Encode(&sc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (sc *structuredConfig) add(fields []string) error {
+	opt, args := fields[0], fields[1:]
+
+	switch opt {
+	case "include":
+		sc.Include = append(sc.Include, args...)
+		return nil
+	case "tracing":
+		m, err := argsTable(args)
+		if err != nil {
+			return err
+		}
+		sc.Tracing = m
+		return nil
+	case "log_level":
+		sc.LogLevel = make(map[string]string)
+		for _, arg := range args {
+			component, level, ok := strings.Cut(arg, "=")
+			if !ok {
+				component, level = "default", arg
+			}
+			sc.LogLevel[component] = level
+		}
+		return nil
+	case "log_sampling":
+		m, err := argsTable(args)
+		if err != nil {
+			return err
+		}
+		sc.LogSampling = m
+		return nil
+	case "writer":
+		if len(args) == 0 {
+			return fmt.Errorf("writer requires at least one argument")
+		}
+
+		m, err := argsTable(args[1:])
+		if err != nil {
+			return err
+		}
+		m["type"] = args[0]
+
+		// Filters are written as a table of the filter keys.
+		if f, ok := m["filter"]; ok {
+			filter, err := nameValueTable(f)
+			if err != nil {
+				return err
+			}
+			m["filter"] = filter
+		}
+
+		sc.Writers = append(sc.Writers, m)
+		return nil
+	case "master_address":
+		if len(args) == 0 {
+			return fmt.Errorf("master_address requires at least one argument")
+		}
+
+		if len(args) == 1 {
+			sc.Masters = append(sc.Masters, args[0])
+			return nil
+		}
+
+		m, err := argsTable(args[1:])
+		if err != nil {
+			return err
+		}
+		m["address"] = args[0]
+		sc.Masters = append(sc.Masters, m)
+		return nil
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("%s requires exactly one argument", opt)
+	}
+
+	switch opt {
+	case "debug":
+		v, err := strconv.ParseBool(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid boolean value: %w", err)
+		}
+		sc.Debug = &v
+	case "listen_address":
+		sc.ListenAddress = args[0]
+	case "http_address":
+		sc.HTTPAddress = args[0]
+	case "history_size":
+		v, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid history_size value: %q", args[0])
+		}
+		sc.HistorySize = v
+	case "log_format":
+		sc.LogFormat = args[0]
+	case "log_output":
+		sc.LogOutput = args[0]
+	default:
+		return fmt.Errorf("unknown config option: %q", opt)
+	}
+
+	return nil
+}
+
+// nameValueTable converts name:value arguments, as returned by argsTable, to
+// a table, repeated names become lists.
+func nameValueTable(v any) (map[string]any, error) {
+	values, ok := v.([]any)
+	if !ok {
+		values = []any{v}
+	}
+
+	args := make([]string, 0, len(values))
+	for _, item := range values {
+		s := item.(string)
+		if !strings.Contains(s, ":") {
+			return nil, fmt.Errorf("expected name:value, got %q", s)
+		}
+		args = append(args, strings.Replace(s, ":", "=", 1))
+	}
+
+	return argsTable(args)
+}
+
+// argsTable converts key=value arguments to a table, repeated keys become
+// lists.
+func argsTable(args []string) (map[string]any, error) {
+	m := make(map[string]any)
+
+	for _, arg := range args {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value, got %q", arg)
+		}
+
+		switch prev := m[k].(type) {
+		case nil:
+			m[k] = v
+		case string:
+			m[k] = []any{prev, v}
+		case []any:
+			m[k] = append(prev, v)
+		}
+	}
+
+	return m, nil
+}
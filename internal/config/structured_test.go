@@ -0,0 +1,114 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const structuredTestConfig = `listen_address 127.0.0.1:27500
+master_address 127.0.0.1:27000
+master_address 127.0.0.1:27001 heartbeat_interval=1m
+http_address 127.0.0.1:8000
+history_size 50
+debug true
+log_format json
+log_level info master=debug writers=warn
+log_sampling interval=10s burst=5
+tracing endpoint=http://127.0.0.1:4318 service_name=qwbs header=Authorization:secret
+writer slogger format=json output=stderr filter=mode:4on4 filter=mode:2on2 filter=server:127.0.0.1:28501
+writer poster format=discord url=http://localhost:4554 username="QW Bot" header=X-Source:qwbs
+writer irc server=irc.quakenet.org:6667 nick=qwbs channel=#qw channel=#qw.pickup rate=2s burst=4
+writer telegram token=123456:ABC chat=-1001234567890 chat=@qwpickup
+writer mqtt broker=localhost:1883 qos=1 retain=true
+`
+
+// TestConvertRoundTrip converts line based configs to YAML and TOML and
+// checks that all of them load to the same configuration.
+func TestConvertRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.conf")
+	if err := os.WriteFile(path, []byte(structuredTestConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"../../qwbs.conf", path} {
+		want := loadComparable(t, path)
+
+		for _, format := range []string{FormatYAML, FormatTOML} {
+			t.Run(filepath.Base(path)+"/"+format, func(t *testing.T) {
+				b, err := Convert(path, format)
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+
+				name := strings.TrimSuffix(filepath.Base(path), ".conf") + "." + format
+				converted := filepath.Join(dir, name)
+				if err := os.WriteFile(converted, b, 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				if got := loadComparable(t, converted); !reflect.DeepEqual(got, want) {
+					t.Errorf("got %+v, want %+v\n%s", got, want, b)
+				}
+			})
+		}
+	}
+}
+
+// loadComparable loads the config at path without the writer instances and
+// the warnings, which refer to the file.
+func loadComparable(t *testing.T, path string) *Config {
+	t.Helper()
+
+	conf, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("%s: got error %v", path, err)
+	}
+
+	conf.CloseWriters()
+	conf.Writers = nil
+	conf.Warnings = nil
+	return conf
+}
+
+func TestStructuredErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qwbs.yaml")
+	text := `listen_address: 127.0.0.1:27500
+masters:
+  - 127.0.0.1:27000
+  - {heartbeat_interval: 1m}
+  - [127.0.0.1:27001]
+writers:
+  - {format: json}
+  - {type: slogger, format: [[json]]}
+  - {type: slogger, format: json, filter: {mode: [4on4, 2on2]}}
+`
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(path, nil)
+	if err == nil {
+		t.Fatal("got no error")
+	}
+
+	for _, want := range []string{
+		"masters[1]: missing master address",
+		"masters[2]: expected an address or a table",
+		"writers[0]: missing writer type",
+		"writers[1]: format: unsupported value",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want it to contain %q", err, want)
+		}
+	}
+
+	// The valid entries are parsed past the invalid ones, so only the
+	// reported errors are returned.
+	if n := strings.Count(err.Error(), "\n") + 1; n != 4 {
+		t.Errorf("got %d errors, want 4:\n%v", n, err)
+	}
+}
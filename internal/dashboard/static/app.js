@@ -0,0 +1,116 @@
+"use strict";
+
+const refreshInterval = 10000;
+
+function el(tag, text, className) {
+  const e = document.createElement(tag);
+  if (text !== undefined) {
+    e.textContent = text;
+  }
+  if (className) {
+    e.className = className;
+  }
+  return e;
+}
+
+function row(cells) {
+  const tr = el("tr");
+  for (const c of cells) {
+    tr.appendChild(c instanceof Node ? c : el("td", c));
+  }
+  return tr;
+}
+
+function formatTime(t) {
+  if (!t || t.startsWith("0001-")) {
+    return "-";
+  }
+  return new Date(t).toLocaleString();
+}
+
+function players(status) {
+  const td = el("td");
+  for (const p of (status && status.players) || []) {
+    if (td.childNodes.length > 0) {
+      td.appendChild(document.createTextNode(", "));
+    }
+    td.appendChild(el("span", p.name, p.spectator ? "spectator" : ""));
+  }
+  return td;
+}
+
+function renderMasters(masters) {
+  const tbody = document.getElementById("masters");
+  tbody.replaceChildren(...masters.map((m) => row([
+    m.address,
+    el("td", m.registered ? "yes" : "no", m.registered ? "online" : "offline"),
+    formatTime(m.last_register),
+    formatTime(m.last_heartbeat),
+    String(m.sequence),
+  ])));
+}
+
+function renderServers(servers) {
+  const tbody = document.getElementById("servers");
+  tbody.replaceChildren(...servers.map((s) => row([
+    s.address,
+    (s.status && s.status.map) || "",
+    (s.status && s.status.mode) || "",
+    players(s.status),
+    formatTime(s.last_seen),
+  ])));
+}
+
+function broadcastRow(d) {
+  return row([
+    formatTime(d.time),
+    d.broadcast.name,
+    d.broadcast.message,
+    d.broadcast.address,
+  ]);
+}
+
+function renderBroadcasts(broadcasts) {
+  const tbody = document.getElementById("broadcasts");
+  tbody.replaceChildren(...broadcasts.map(broadcastRow));
+}
+
+async function refresh() {
+  try {
+    const res = await fetch("api/status");
+    if (!res.ok) {
+      throw new Error(res.statusText);
+    }
+
+    const status = await res.json();
+    document.getElementById("version").textContent = status.version;
+    renderMasters(status.masters || []);
+    renderServers(status.servers || []);
+    renderBroadcasts(status.broadcasts || []);
+  } catch (err) {
+    console.error("Failed to refresh status", err);
+  }
+}
+
+function subscribe() {
+  const indicator = document.getElementById("connection");
+  const events = new EventSource("events");
+
+  events.onopen = () => {
+    indicator.textContent = "live";
+    indicator.className = "online";
+  };
+
+  events.onerror = () => {
+    indicator.textContent = "offline";
+    indicator.className = "offline";
+  };
+
+  events.addEventListener("broadcast", () => {
+    refresh();
+  });
+}
+
+refresh();
+subscribe();
+setInterval(refresh, refreshInterval);
@@ -0,0 +1,197 @@
+package feed
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/textfmt"
+)
+
+const (
+	title    = "QuakeWorld broadcasts"
+	atomNS   = "http://www.w3.org/2005/Atom"
+	guidBase = "urn:qwbs:broadcast:"
+)
+
+type Filter struct {
+	Server string
+	Mode   string
+	Limit  int
+}
+
+func (f Filter) Apply(entries []*writer.Data) []*writer.Data {
+	var filtered []*writer.Data
+	for _, d := range entries {
+		if f.Server != "" && d.Broadcast.Address != f.Server {
+			continue
+		}
+
+		if f.Mode != "" && !strings.EqualFold(d.Server.Mode, f.Mode) {
+			continue
+		}
+
+		filtered = append(filtered, d)
+		if f.Limit > 0 && len(filtered) == f.Limit {
+			break
+		}
+	}
+
+	return filtered
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Author  atomAuthor `xml:"author"`
+	Summary string     `xml:"summary"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Description string  `xml:"description"`
+	PubDate     string  `xml:"pubDate"`
+	GUID        rssGUID `xml:"guid"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// WriteAtom renders the entries as an Atom feed. The entries are expected to
+// be ordered newest first.
+func WriteAtom(w io.Writer, link string, entries []*writer.Data) error {
+	feed := atomFeed{
+		XMLNS:   atomNS,
+		ID:      link,
+		Title:   title,
+		Updated: updated(entries).Format(time.RFC3339),
+		Link:    atomLink{Href: link, Rel: "self"},
+		Author:  atomAuthor{Name: "qwbs"},
+	}
+
+	for _, d := range entries {
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      guid(d),
+			Title:   entryTitle(d),
+			Updated: d.Time.UTC().Format(time.RFC3339),
+			Author:  atomAuthor{Name: d.Broadcast.Name},
+			Summary: summary(d),
+		})
+	}
+
+	return write(w, feed)
+}
+
+// WriteRSS renders the entries as an RSS 2.0 feed. The entries are expected
+// to be ordered newest first.
+func WriteRSS(w io.Writer, link string, entries []*writer.Data) error {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        link,
+			Description: title,
+		},
+	}
+
+	if len(entries) > 0 {
+		feed.Channel.LastBuildDate = updated(entries).Format(time.RFC1123Z)
+	}
+
+	for _, d := range entries {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       entryTitle(d),
+			Description: summary(d),
+			PubDate:     d.Time.UTC().Format(time.RFC1123Z),
+			GUID:        rssGUID{Value: guid(d)},
+		})
+	}
+
+	return write(w, feed)
+}
+
+func write(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(v)
+}
+
+func updated(entries []*writer.Data) time.Time {
+	if len(entries) == 0 {
+		return time.Now().UTC()
+	}
+
+	return entries[0].Time.UTC()
+}
+
+// guid derives a stable identifier from the broadcast so that the same
+// entry keeps its identity across feed refreshes.
+func guid(d *writer.Data) string {
+	h := sha1.New()
+	h.Write([]byte(d.Broadcast.Address))
+	h.Write([]byte(strconv.FormatInt(d.Time.UnixNano(), 10)))
+	h.Write([]byte(d.Broadcast.Name))
+	h.Write([]byte(d.Broadcast.Message))
+	return guidBase + hex.EncodeToString(h.Sum(nil))
+}
+
+func entryTitle(d *writer.Data) string {
+	return fmt.Sprintf("%s: %s", d.Broadcast.Name, d.Broadcast.Message)
+}
+
+func summary(d *writer.Data) string {
+	s := fmt.Sprintf("[%s/%s] %s %s @ %s",
+		d.Players(), d.MaxPlayers(), d.Server.Mode, d.Server.Map, d.Broadcast.Address)
+	if playing := d.Server.Playing(); len(playing) > 0 {
+		s += " - " + textfmt.Join(playing, ", ")
+	}
+
+	return s
+}
@@ -0,0 +1,94 @@
+package history
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+const (
+	DefaultSize = 100
+)
+
+type Server struct {
+	Address  string               `json:"address"`
+	Status   *serverstatus.Server `json:"status"`
+	LastSeen time.Time            `json:"last_seen"`
+}
+
+type History struct {
+	size int
+
+	mu      sync.RWMutex
+	entries []*writer.Data
+	servers map[string]*Server
+}
+
+func New(size int) *History {
+	if size <= 0 {
+		size = DefaultSize
+	}
+
+	return &History{
+		size:    size,
+		servers: make(map[string]*Server),
+	}
+}
+
+// Close does nothing, the history is only kept in memory.
+func (h *History) Close() error {
+	return nil
+}
+
+func (h *History) Write(_ context.Context, _ *slog.Logger, data *writer.Data) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries = append(h.entries, data)
+	if len(h.entries) > h.size {
+		h.entries = h.entries[len(h.entries)-h.size:]
+	}
+
+	h.servers[data.Broadcast.Address] = &Server{
+		Address:  data.Broadcast.Address,
+		Status:   data.Server,
+		LastSeen: data.Time,
+	}
+
+	return nil
+}
+
+// Recent returns the most recent entries, newest first.
+func (h *History) Recent() []*writer.Data {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	entries := make([]*writer.Data, len(h.entries))
+	for i, d := range h.entries {
+		entries[len(h.entries)-1-i] = d
+	}
+
+	return entries
+}
+
+// Servers returns the known servers, most recently seen first.
+func (h *History) Servers() []*Server {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	servers := make([]*Server, 0, len(h.servers))
+	for _, s := range h.servers {
+		servers = append(servers, s)
+	}
+
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].LastSeen.After(servers[j].LastSeen)
+	})
+
+	return servers
+}
@@ -0,0 +1,284 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/osm/qwbs/internal/writer/rotate"
+)
+
+const (
+	ComponentKey = "component"
+)
+
+const (
+	ComponentMaster       = "master"
+	ComponentServer       = "server"
+	ComponentServerStatus = "serverstatus"
+	ComponentWriters      = "writers"
+)
+
+var Components = []string{
+	ComponentMaster,
+	ComponentServer,
+	ComponentServerStatus,
+	ComponentWriters,
+}
+
+type Sampling struct {
+	Interval time.Duration
+	Burst    int
+}
+
+// Options configures the service logger. Levels holds the level of each
+// component, where the empty key is the level of everything else.
+type Options struct {
+	Format   string
+	Output   string
+	Levels   map[string]slog.Level
+	Sampling *Sampling
+}
+
+type Logging struct {
+	logger *slog.Logger
+	levels *levels
+	file   *rotate.File
+	opts   Options
+}
+
+func New(opts Options) (*Logging, error) {
+	var out io.Writer
+	var file *rotate.File
+
+	switch opts.Output {
+	case "", "stderr":
+		out = os.Stderr
+	case "stdout":
+		out = os.Stdout
+	default:
+		file = rotate.New(rotate.Options{Path: opts.Output})
+		out = file
+	}
+
+	// The handler logs everything it's given, filtering on level is done
+	// per component by the wrapping handler.
+	handlerOpts := &slog.HandlerOptions{Level: slog.Level(-8)}
+
+	var next slog.Handler
+	switch opts.Format {
+	case "", "text":
+		next = slog.NewTextHandler(out, handlerOpts)
+	case "json":
+		handlerOpts.ReplaceAttr = stringerAttr
+		next = slog.NewJSONHandler(out, handlerOpts)
+	default:
+		return nil, fmt.Errorf("unknown log format: %q", opts.Format)
+	}
+
+	l := &Logging{
+		levels: &levels{},
+		file:   file,
+		opts:   opts,
+	}
+	l.levels.set(opts.Levels)
+
+	h := &handler{next: next, levels: l.levels}
+	if opts.Sampling != nil {
+		h.sampler = newSampler(*opts.Sampling)
+	}
+	l.logger = slog.New(h)
+
+	return l, nil
+}
+
+func (l *Logging) Logger() *slog.Logger {
+	return l.logger
+}
+
+// Reload replaces the component levels with those of a reloaded
+// configuration. The format, output and sampling can't be changed without a
+// restart, so a warning is logged if they differ from the running ones.
+func (l *Logging) Reload(opts Options) {
+	l.levels.set(opts.Levels)
+
+	if orDefault(opts.Format, "text") != orDefault(l.opts.Format, "text") {
+		l.logger.Warn("Changing log_format requires a restart")
+	}
+
+	if orDefault(opts.Output, "stderr") != orDefault(l.opts.Output, "stderr") {
+		l.logger.Warn("Changing log_output requires a restart")
+	}
+
+	if !reflect.DeepEqual(opts.Sampling, l.opts.Sampling) {
+		l.logger.Warn("Changing log_sampling requires a restart")
+	}
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+
+	return s
+}
+
+// Reopen reopens the log file, if any, so that it can be rotated by external
+// tools.
+func (l *Logging) Reopen() error {
+	if l.file == nil {
+		return nil
+	}
+
+	return l.file.Reopen()
+}
+
+func (l *Logging) Close() error {
+	if l.file == nil {
+		return nil
+	}
+
+	return l.file.Close()
+}
+
+type levels struct {
+	mu     sync.RWMutex
+	levels map[string]slog.Level
+}
+
+func (l *levels) set(levels map[string]slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.levels = levels
+}
+
+func (l *levels) get(component string) slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if level, ok := l.levels[component]; ok {
+		return level
+	}
+
+	return l.levels[""]
+}
+
+// handler filters records on the level of the component, which is taken
+// from the component attribute added with slog.Logger.With.
+type handler struct {
+	next      slog.Handler
+	levels    *levels
+	component string
+	sampler   *sampler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.levels.get(h.component)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if h.sampler != nil && r.Level < slog.LevelInfo {
+		ok, dropped := h.sampler.allow(r.Message, r.Time)
+		if !ok {
+			return nil
+		}
+
+		if dropped > 0 {
+			r = r.Clone()
+			r.AddAttrs(slog.Int("sampled", dropped))
+		}
+	}
+
+	return h.next.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+
+	for _, a := range attrs {
+		if a.Key == ComponentKey {
+			c.component = a.Value.String()
+		}
+	}
+
+	return &c
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.next = h.next.WithGroup(name)
+	return &c
+}
+
+// sampler limits how often the same debug message is logged. The first
+// burst records of a message are logged in each interval and the rest are
+// dropped, the number of dropped records is reported on the next record that
+// is logged.
+type sampler struct {
+	opts Sampling
+
+	mu       sync.Mutex
+	messages map[string]*sample
+}
+
+type sample struct {
+	start   time.Time
+	count   int
+	dropped int
+}
+
+func newSampler(opts Sampling) *sampler {
+	return &sampler{
+		opts:     opts,
+		messages: make(map[string]*sample),
+	}
+}
+
+func (s *sampler) allow(msg string, now time.Time) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.messages[msg]
+	if !ok || now.Sub(m.start) >= s.opts.Interval {
+		dropped := 0
+		if ok {
+			dropped = m.dropped
+		}
+
+		s.messages[msg] = &sample{start: now, count: 1}
+		return true, dropped
+	}
+
+	if m.count < s.opts.Burst {
+		m.count++
+		return true, 0
+	}
+
+	m.dropped++
+	return false, 0
+}
+
+// stringerAttr logs values such as addresses by their string representation,
+// which the JSON handler would otherwise encode as objects.
+func stringerAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() != slog.KindAny {
+		return a
+	}
+
+	if _, ok := a.Value.Any().(error); ok {
+		return a
+	}
+
+	if s, ok := a.Value.Any().(fmt.Stringer); ok {
+		return slog.String(a.Key, s.String())
+	}
+
+	return a
+}
@@ -0,0 +1,38 @@
+package logging
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qwbs.log")
+	l, err := New(Options{Output: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The defaults are the same as giving them explicitly.
+	l.Reload(Options{Format: "text", Output: path, Levels: map[string]slog.Level{"": slog.LevelWarn}})
+	l.Reload(Options{Format: "json", Output: "stdout", Sampling: &Sampling{Interval: time.Second, Burst: 1}})
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(b)
+
+	if n := strings.Count(out, "requires a restart"); n != 3 {
+		t.Errorf("got %d warnings, want one each for the format, output and sampling:\n%s", n, out)
+	}
+	for _, opt := range []string{"log_format", "log_output", "log_sampling"} {
+		if !strings.Contains(out, "Changing "+opt+" requires a restart") {
+			t.Errorf("got no warning for %s", opt)
+		}
+	}
+}
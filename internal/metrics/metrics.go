@@ -0,0 +1,388 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var Default = NewRegistry()
+
+type collector interface {
+	write(w io.Writer) error
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.collectors[name]; ok {
+		panic(fmt.Sprintf("metric %q registered twice", name))
+	}
+
+	r.collectors[name] = c
+}
+
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		r.mu.Lock()
+		c := r.collectors[name]
+		r.mu.Unlock()
+
+		if err := c.write(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		r.Write(w)
+	})
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %q expects %d label values, got %d",
+			d.name, len(d.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+func (d *desc) header(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ)
+	return err
+}
+
+func (d *desc) labelPairs(values []string, extra ...string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, v := range values {
+		pairs = append(pairs, d.labels[i]+`="`+escapeLabel(v)+`"`)
+	}
+	pairs = append(pairs, extra...)
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type series struct {
+	values []string
+	value  float64
+}
+
+type vec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (v *vec) get(values []string) *series {
+	key := v.key(values)
+
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		v.series[key] = s
+	}
+
+	return s
+}
+
+// Delete removes the series with the label values, e.g. for a target that no
+// longer exists.
+func (v *vec) Delete(labels ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.series, v.key(labels))
+}
+
+func (v *vec) write(w io.Writer) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := v.header(w); err != nil {
+		return err
+	}
+
+	for _, s := range sortedSeries(v.series) {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelPairs(s.values), formatFloat(s.value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type Counter struct {
+	vec
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec{
+		desc:   desc{name: name, help: help, typ: "counter", labels: labels},
+		series: make(map[string]*series),
+	}}
+	if len(labels) == 0 {
+		c.get(nil)
+	}
+
+	Default.register(name, c)
+	return c
+}
+
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+func (c *Counter) Add(v float64, labels ...string) {
+	if v < 0 {
+		panic("counter cannot decrease")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.get(labels).value += v
+}
+
+type Gauge struct {
+	vec
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec{
+		desc:   desc{name: name, help: help, typ: "gauge", labels: labels},
+		series: make(map[string]*series),
+	}}
+	if len(labels) == 0 {
+		g.get(nil)
+	}
+
+	Default.register(name, g)
+	return g
+}
+
+func (g *Gauge) Set(v float64, labels ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.get(labels).value = v
+}
+
+func (g *Gauge) Add(v float64, labels ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.get(labels).value += v
+}
+
+func (g *Gauge) Inc(labels ...string) {
+	g.Add(1, labels...)
+}
+
+func (g *Gauge) Dec(labels ...string) {
+	g.Add(-1, labels...)
+}
+
+type gaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn on every
+// scrape.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.register(name, &gaugeFunc{
+		desc: desc{name: name, help: help, typ: "gauge"},
+		fn:   fn,
+	})
+}
+
+func (g *gaugeFunc) write(w io.Writer) error {
+	if err := g.header(w); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+	return err
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type Histogram struct {
+	desc
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+
+	h := &Histogram{
+		desc:    desc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	Default.register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labels ...string) {
+	key := h.key(labels)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			values: append([]string(nil), labels...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Delete removes the series with the label values.
+func (h *Histogram) Delete(labels ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.series, h.key(labels))
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.header(w); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := h.series[k]
+		for i, b := range h.buckets {
+			le := `le="` + formatFloat(b) + `"`
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.values, le), s.counts[i]); err != nil {
+				return err
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.values, `le="+Inf"`), s.count); err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.values), formatFloat(s.sum)); err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(s.values), s.count); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sortedSeries(m map[string]*series) []*series {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := make([]*series, len(keys))
+	for i, k := range keys {
+		s[i] = m[k]
+	}
+
+	return s
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
@@ -14,6 +14,8 @@ type Broadcast struct {
 	Message    string `json:"message"`
 	Name       string `json:"name"`
 	Players    string `json:"players"`
+	RawMessage string `json:"-"`
+	RawName    string `json:"-"`
 }
 
 func Parse(clientAddr *net.UDPAddr, payload []byte) (*Broadcast, error) {
@@ -38,6 +40,8 @@ func Parse(clientAddr *net.UDPAddr, payload []byte) (*Broadcast, error) {
 		Message:    message,
 		Name:       name,
 		Players:    players,
+		RawMessage: infostring.GetRaw(info, "message"),
+		RawName:    infostring.GetRaw(info, "name"),
 	}
 	return bc, nil
 }
@@ -2,29 +2,77 @@ package charset
 
 import "strings"
 
+type Color uint8
+
+const (
+	White Color = iota
+	Brown
+	Gold
+)
+
+type Segment struct {
+	Text  string
+	Color Color
+}
+
+var special = [32]string{
+	"", "", "", "", "", ".", "", "",
+	"", "", "", "", "", ">", ".", ".",
+	"[", "]", "0", "1", "2", "3", "4", "5",
+	"6", "7", "8", "9", ".", "<", "=", ">",
+}
+
 func Parse(input string) string {
 	var result strings.Builder
 
-	special := [32]string{
-		"", "", "", "", "", ".", "", "",
-		"", "", "", "", "", ">", ".", ".",
-		"[", "]", "0", "1", "2", "3", "4", "5",
-		"6", "7", "8", "9", ".", "<", "=", ">",
+	for _, b := range []byte(input) {
+		s, _, ok := decode(b)
+		if ok {
+			result.WriteString(s)
+		}
 	}
 
+	return result.String()
+}
+
+func Segments(input string) []Segment {
+	var segments []Segment
+
 	for _, b := range []byte(input) {
-		b &= 0x7f
+		s, color, ok := decode(b)
+		if !ok || s == "" {
+			continue
+		}
 
-		if b == 0x7f {
+		if n := len(segments); n > 0 && segments[n-1].Color == color {
+			segments[n-1].Text += s
 			continue
 		}
 
-		if b < 32 {
-			result.WriteString(special[b])
-		} else {
-			result.WriteByte(b)
+		segments = append(segments, Segment{Text: s, Color: color})
+	}
+
+	return segments
+}
+
+func decode(b byte) (string, Color, bool) {
+	color := White
+	if b&0x80 != 0 {
+		color = Brown
+	}
+
+	b &= 0x7f
+
+	if b == 0x7f {
+		return "", color, false
+	}
+
+	if b < 32 {
+		if b >= 0x10 && b <= 0x1b {
+			color = Gold
 		}
+		return special[b], color, true
 	}
 
-	return result.String()
+	return string(rune(b)), color, true
 }
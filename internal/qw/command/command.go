@@ -27,9 +27,26 @@ var (
 	print        = []byte{0x6e}
 	shutdown     = []byte{0x43, 0x0a}
 	status       = []byte("status")
-	statusQuery  = []byte("status 19")
+	statusQuery  = []byte("status 23") // serverinfo, players, spectators and teams
 )
 
+func (c Command) String() string {
+	switch c {
+	case ACK:
+		return "ack"
+	case Broadcast:
+		return "broadcast"
+	case GetChallenge:
+		return "getchallenge"
+	case Ping:
+		return "ping"
+	case Status:
+		return "status"
+	default:
+		return "unknown"
+	}
+}
+
 func Parse(buf []byte) (Command, []byte) {
 	headerLen := len(header)
 	if len(buf) < headerLen || !bytes.Equal(buf[:headerLen], header) {
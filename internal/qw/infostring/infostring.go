@@ -26,10 +26,14 @@ func Parse(data []byte) (map[string]string, error) {
 }
 
 func Get(info map[string]string, key string) string {
+	return charset.Parse(GetRaw(info, key))
+}
+
+func GetRaw(info map[string]string, key string) string {
 	value, ok := info[key]
 	if !ok {
 		return "unknown"
 	}
 
-	return charset.Parse(value)
+	return value
 }
@@ -7,6 +7,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/osm/qwbs/internal/metrics"
 	"github.com/osm/qwbs/internal/qw/command"
 )
 
@@ -15,24 +16,77 @@ const (
 	registerInterval  = time.Second * 60
 )
 
+var (
+	registeredGauge = metrics.NewGauge("qwbs_master_registered",
+		"Whether the master server has acknowledged the registration.", "master")
+	sequenceGauge = metrics.NewGauge("qwbs_master_heartbeat_sequence",
+		"Sequence number of the next heartbeat sent to the master server.", "master")
+	registrationsTotal = metrics.NewCounter("qwbs_master_registrations_total",
+		"Registration attempts sent to the master server.", "master")
+	heartbeatsTotal = metrics.NewCounter("qwbs_master_heartbeats_total",
+		"Heartbeats sent to the master server.", "master", "result")
+)
+
+// Options are the settings of a master server, zero values use the
+// defaults.
+type Options struct {
+	HeartbeatInterval time.Duration
+}
+
 type Master struct {
-	conn         *net.UDPConn
-	addr         *net.UDPAddr
-	logger       *slog.Logger
-	isRunning    int32
-	isRegistered int32
-	lastRegister atomic.Value
-	sequence     int
+	conn          *net.UDPConn
+	addr          *net.UDPAddr
+	opts          Options
+	logger        *slog.Logger
+	isRunning     int32
+	isRegistered  int32
+	lastRegister  atomic.Value
+	lastHeartbeat atomic.Value
+	sequence      atomic.Int64
+}
+
+type Status struct {
+	Address       string    `json:"address"`
+	Registered    bool      `json:"registered"`
+	LastRegister  time.Time `json:"last_register"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	Sequence      int64     `json:"sequence"`
 }
 
-func New(conn *net.UDPConn, addr *net.UDPAddr, logger *slog.Logger) *Master {
+func New(conn *net.UDPConn, addr *net.UDPAddr, opts Options, logger *slog.Logger) *Master {
+	registeredGauge.Set(0, addr.String())
+	sequenceGauge.Set(0, addr.String())
+
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = heartbeatInterval
+	}
+
 	return &Master{
 		conn:   conn,
 		addr:   addr,
+		opts:   opts,
 		logger: logger,
 	}
 }
 
+func (m *Master) Status() Status {
+	st := Status{
+		Address:    m.addr.String(),
+		Registered: atomic.LoadInt32(&m.isRegistered) == 1,
+		Sequence:   m.sequence.Load(),
+	}
+
+	if t, ok := m.lastRegister.Load().(time.Time); ok {
+		st.LastRegister = t
+	}
+
+	if t, ok := m.lastHeartbeat.Load().(time.Time); ok {
+		st.LastHeartbeat = t
+	}
+
+	return st
+}
+
 func (m *Master) Register() error {
 	if atomic.LoadInt32(&m.isRegistered) == 1 {
 		return nil
@@ -50,6 +104,7 @@ func (m *Master) Register() error {
 	}
 
 	m.lastRegister.Store(time.Now())
+	registrationsTotal.Inc(m.addr.String())
 	m.logger.Info("Registering with master server", "master", m.addr)
 	return nil
 }
@@ -64,10 +119,20 @@ func (m *Master) Unregister() error {
 	}
 
 	atomic.StoreInt32(&m.isRegistered, 0)
+	registeredGauge.Set(0, m.addr.String())
 	m.logger.Info("Unregistering from master server", "master", m.addr)
 	return nil
 }
 
+// Close unregisters from the master server and removes its metrics, it's
+// used when the master is removed from the configuration.
+func (m *Master) Close() error {
+	err := m.Unregister()
+	registeredGauge.Delete(m.addr.String())
+	sequenceGauge.Delete(m.addr.String())
+	return err
+}
+
 func (m *Master) Heartbeat(ctx context.Context) {
 	if !atomic.CompareAndSwapInt32(&m.isRunning, 0, 1) {
 		return
@@ -75,24 +140,29 @@ func (m *Master) Heartbeat(ctx context.Context) {
 	defer atomic.StoreInt32(&m.isRunning, 0)
 
 	atomic.StoreInt32(&m.isRegistered, 1)
+	registeredGauge.Set(1, m.addr.String())
 
-	ticker := time.NewTicker(heartbeatInterval)
+	ticker := time.NewTicker(m.opts.HeartbeatInterval)
 	defer ticker.Stop()
 
 	for {
+		sequence := m.sequence.Load()
 		m.logger.Info("Sending heartbeat to master server",
 			"addr", m.addr,
-			"sequence", m.sequence,
+			"sequence", sequence,
 		)
 
-		_, err := m.conn.WriteToUDP(command.GetHeartbeatBytes(m.sequence), m.addr)
+		_, err := m.conn.WriteToUDP(command.GetHeartbeatBytes(int(sequence)), m.addr)
 		if err != nil {
 			m.logger.Error("Failed to send heartbeat to master server",
-				"master", m.addr, "sequence", m.sequence, "error", err)
+				"master", m.addr, "sequence", sequence, "error", err)
+			heartbeatsTotal.Inc(m.addr.String(), "failure")
 			continue
 		}
 
-		m.sequence++
+		m.lastHeartbeat.Store(time.Now())
+		heartbeatsTotal.Inc(m.addr.String(), "success")
+		sequenceGauge.Set(float64(m.sequence.Add(1)), m.addr.String())
 
 		select {
 		case <-ticker.C:
@@ -2,9 +2,11 @@ package serverstatus
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"sort"
 	"strings"
 	"time"
 
@@ -16,6 +18,7 @@ import (
 const (
 	bufSize     = 1024 * 64
 	readTimeout = time.Second
+	specPrefix  = `\s\`
 )
 
 type Server struct {
@@ -26,17 +29,64 @@ type Server struct {
 }
 
 type Player struct {
-	Name string `json:"name"`
-	Team string `json:"team"`
+	Name      string `json:"name"`
+	Team      string `json:"team"`
+	Spectator bool   `json:"spectator"`
 }
 
-func Query(serverAddr string) (*Server, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp", serverAddr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve addr %q: %w", serverAddr, err)
+type Team struct {
+	Name    string   `json:"name"`
+	Players []Player `json:"players"`
+}
+
+func (s *Server) Playing() []Player {
+	var players []Player
+	for _, p := range s.Players {
+		if !p.Spectator {
+			players = append(players, p)
+		}
+	}
+
+	return players
+}
+
+func (s *Server) Spectators() []Player {
+	var spectators []Player
+	for _, p := range s.Players {
+		if p.Spectator {
+			spectators = append(spectators, p)
+		}
+	}
+
+	return spectators
+}
+
+func (s *Server) Teams() []Team {
+	members := make(map[string][]Player)
+	for _, p := range s.Playing() {
+		members[p.Team] = append(members[p.Team], p)
+	}
+
+	teams := make([]Team, 0, len(members))
+	for name, players := range members {
+		teams = append(teams, Team{Name: name, Players: players})
 	}
 
-	conn, err := net.DialUDP("udp", nil, udpAddr)
+	sort.Slice(teams, func(i, j int) bool {
+		return teams[i].Name < teams[j].Name
+	})
+
+	return teams
+}
+
+func Query(serverAddr string) (*Server, error) {
+	return QueryContext(context.Background(), serverAddr)
+}
+
+// QueryContext is like Query, but gives up when ctx is done.
+func QueryContext(ctx context.Context, serverAddr string) (*Server, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", serverAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to perform UDP dial: %w", err)
 	}
@@ -47,10 +97,21 @@ func Query(serverAddr string) (*Server, error) {
 		return nil, fmt.Errorf("failed to send status query: %w", err)
 	}
 
-	if err := conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+	deadline := time.Now().Add(readTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	if err := conn.SetReadDeadline(deadline); err != nil {
 		return nil, fmt.Errorf("failed to set read deadline: %w", err)
 	}
 
+	// Cancelling ctx unblocks the read below.
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
 	buf := make([]byte, bufSize)
 	recvLen, err := conn.Read(buf)
 	if err != nil {
@@ -114,9 +175,11 @@ func parsePlayers(data []byte) ([]Player, error) {
 			return players, fmt.Errorf("malformed player line: %q", line)
 		}
 
+		name, spectator := strings.CutPrefix(fields[4], specPrefix)
 		players = append(players, Player{
-			Name: charset.Parse(fields[4]),
-			Team: charset.Parse(fields[8]),
+			Name:      charset.Parse(name),
+			Team:      charset.Parse(fields[8]),
+			Spectator: spectator,
 		})
 	}
 
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/osm/qwbs/internal/systemd"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+const (
+	healthDeadline = readTimeout * 10
+)
+
+// Healthy reports whether the UDP read loop is still running, which is
+// detected by it having returned from a read within the deadline.
+func (s *Server) Healthy() error {
+	last := s.lastRead.Load()
+	if last == 0 {
+		return errors.New("server is not listening")
+	}
+
+	if since := time.Since(time.Unix(0, last)); since > healthDeadline {
+		return fmt.Errorf("no read from UDP socket in %s", since.Round(time.Second))
+	}
+
+	return nil
+}
+
+// Ready reports whether at least one master server has acknowledged the
+// registration and all writers that can be checked are healthy.
+func (s *Server) Ready() error {
+	if err := s.Healthy(); err != nil {
+		return err
+	}
+
+	registered := false
+	for _, st := range s.masterStatus() {
+		if st.Registered {
+			registered = true
+			break
+		}
+	}
+	if !registered {
+		return errors.New("no master server has acknowledged the registration")
+	}
+
+	s.writersMu.RLock()
+	writers := append([]*writerEntry(nil), s.writers...)
+	s.writersMu.RUnlock()
+
+	for _, e := range writers {
+		c, ok := e.writer.(writer.Checker)
+		if !ok {
+			continue
+		}
+
+		if err := c.Check(); err != nil {
+			labels := e.labels.Load()
+			return fmt.Errorf("writer %s/%s: %w", labels.name, labels.id, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeCheck(w, s.Healthy())
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	writeCheck(w, s.Ready())
+}
+
+func writeCheck(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Server) runWatchdog(ctx context.Context) {
+	interval := systemd.WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Healthy(); err != nil {
+					s.logger.Error("Skipping watchdog notification", "error", err)
+					continue
+				}
+
+				if err := systemd.Notify(systemd.Watchdog); err != nil {
+					s.logger.Error("Failed to notify watchdog", "error", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
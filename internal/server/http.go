@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/osm/qwbs/internal/dashboard"
+	"github.com/osm/qwbs/internal/feed"
+	"github.com/osm/qwbs/internal/history"
+	"github.com/osm/qwbs/internal/logging"
+	"github.com/osm/qwbs/internal/metrics"
+	"github.com/osm/qwbs/internal/qw/master"
+	"github.com/osm/qwbs/internal/version"
+	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/live"
+)
+
+const (
+	httpTimeout     = time.Second * 10
+	shutdownTimeout = time.Second * 5
+)
+
+type status struct {
+	Version    string            `json:"version"`
+	Masters    []master.Status   `json:"masters"`
+	Servers    []*history.Server `json:"servers"`
+	Broadcasts []*writer.Data    `json:"broadcasts"`
+}
+
+func (s *Server) runHTTP(ctx context.Context) {
+	if s.conf.HTTPAddress == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", dashboard.Handler())
+	mux.HandleFunc("/api/status", s.handleAPIStatus)
+	mux.Handle("/metrics", metrics.Default.Handler())
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/feed.atom", s.handleFeed)
+	mux.HandleFunc("/feed.rss", s.handleFeed)
+
+	mux.HandleFunc("/events", s.handleLive)
+	mux.HandleFunc("/ws", s.handleLive)
+
+	srv := &http.Server{
+		Addr:              s.conf.HTTPAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: httpTimeout,
+		BaseContext: func(_ net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		s.logger.Info("Serving HTTP", "address", s.conf.HTTPAddress)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("HTTP server failed", "address", s.conf.HTTPAddress, "error", err)
+		}
+	}()
+}
+
+func (s *Server) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
+	st := status{
+		Version:    version.Short(),
+		Masters:    s.masterStatus(),
+		Servers:    s.history.Servers(),
+		Broadcasts: s.history.Recent(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		s.logger.Error("Failed to encode status", "client", r.RemoteAddr, "error", err)
+	}
+}
+
+// handleLive serves the live feed writer without an address of its own. It's
+// looked up for every request since the writer can change on reload.
+func (s *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	s.writersMu.RLock()
+	var feed *live.Live
+	for _, e := range s.writers {
+		if l, ok := e.writer.(*live.Live); ok && l.Address() == "" {
+			feed = l
+			break
+		}
+	}
+	s.writersMu.RUnlock()
+
+	if feed == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	feed.Handler(s.loggers[logging.ComponentWriters]).ServeHTTP(w, r)
+}
+
+func (s *Server) masterStatus() []master.Status {
+	s.mastersMu.RLock()
+	defer s.mastersMu.RUnlock()
+
+	statuses := make([]master.Status, 0, len(s.masters))
+	for _, e := range s.masters {
+		statuses = append(statuses, e.master.Status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Address < statuses[j].Address
+	})
+
+	return statuses
+}
+
+func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := feed.Filter{
+		Server: q.Get("server"),
+		Mode:   q.Get("mode"),
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	link := scheme + "://" + r.Host + r.URL.RequestURI()
+
+	entries := filter.Apply(s.history.Recent())
+
+	var err error
+	if strings.HasSuffix(r.URL.Path, ".rss") {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		err = feed.WriteRSS(w, link, entries)
+	} else {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		err = feed.WriteAtom(w, link, entries)
+	}
+	if err != nil {
+		s.logger.Error("Failed to write feed", "client", r.RemoteAddr, "error", err)
+	}
+}
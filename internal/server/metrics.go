@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/osm/qwbs/internal/metrics"
+)
+
+var (
+	packetsTotal = metrics.NewCounter("qwbs_packets_received_total",
+		"UDP packets received by command type.", "command")
+	unknownPacketsTotal = metrics.NewCounter("qwbs_unknown_packets_total",
+		"UDP packets received that could not be parsed as a known command.")
+	broadcastsTotal = metrics.NewCounter("qwbs_broadcasts_total",
+		"Broadcasts received by parse result.", "result")
+	statusQueriesTotal = metrics.NewCounter("qwbs_status_queries_total",
+		"Server status queries by result.", "result")
+	statusQueryDuration = metrics.NewHistogram("qwbs_status_query_duration_seconds",
+		"Duration of server status queries.", nil)
+	writerWritesTotal = metrics.NewCounter("qwbs_writer_writes_total",
+		"Broadcasts delivered to writers by result.", "writer", "id", "result")
+	writerDuration = metrics.NewHistogram("qwbs_writer_duration_seconds",
+		"Duration of writer deliveries.", nil, "writer", "id")
+	writersInFlight = metrics.NewGauge("qwbs_writers_in_flight",
+		"Writer deliveries currently in progress.")
+)
+
+func init() {
+	metrics.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.",
+		func() float64 {
+			return float64(runtime.NumGoroutine())
+		})
+}
+
+type writerLabels struct {
+	name string
+	id   string
+}
+
+// newWriterLabels returns the labels of a writer, where the id is a digest of
+// its spec so that the series of a writer are kept when other writers are
+// added or removed on reload. The spec may hold secrets such as tokens, so
+// it's not used as is. A spec that occurs more than once gets its occurrence
+// appended, and the internal writers have no spec.
+func newWriterLabels(w any, spec string, n int) writerLabels {
+	// Use the package name of the writer type, e.g. *poster.Poster becomes
+	// poster.
+	name := strings.TrimPrefix(fmt.Sprintf("%T", w), "*")
+	name, _, _ = strings.Cut(name, ".")
+
+	id := "internal"
+	if spec != "" {
+		sum := sha256.Sum256([]byte(spec))
+		id = hex.EncodeToString(sum[:4])
+	}
+	if n > 1 {
+		id += "-" + strconv.Itoa(n)
+	}
+
+	return writerLabels{name: name, id: id}
+}
+
+// deleteWriterSeries removes the series of a writer that has been removed.
+func deleteWriterSeries(labels *writerLabels) {
+	writerDuration.Delete(labels.name, labels.id)
+	for _, r := range []string{"success", "failure"} {
+		writerWritesTotal.Delete(labels.name, labels.id, r)
+	}
+}
+
+// observeWrite records the result of delivering a broadcast to a writer,
+// start is when the delivery was started or queued.
+func observeWrite(labels *writerLabels, start time.Time, err error) {
+	writerDuration.Observe(time.Since(start).Seconds(), labels.name, labels.id)
+	writerWritesTotal.Inc(labels.name, labels.id, result(err))
+}
+
+// result returns the value of the result label for err.
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+
+	return "success"
+}
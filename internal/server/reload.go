@@ -0,0 +1,207 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/osm/qwbs/internal/config"
+	"github.com/osm/qwbs/internal/qw/master"
+)
+
+const (
+	stopTimeout = time.Second * 30
+)
+
+// Reload applies a new configuration to the running server. Masters and
+// writers are compared with the running ones and only those that changed are
+// started or stopped, so the UDP socket and the registration state of the
+// unchanged masters are kept. Options that are bound to the socket or the
+// HTTP server are only logged as requiring a restart.
+func (s *Server) Reload(conf *config.Config) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if s.ctx == nil {
+		return errors.New("server is not running")
+	}
+
+	s.warnRestartRequired(conf)
+
+	addedMasters, removedMasters := s.reloadMasters(conf.MasterAddresses, conf.MasterOptions)
+	addedWriters, removedWriters := s.reloadWriters(conf)
+
+	// Keep the options that can't be changed without a restart, so that the
+	// server keeps reporting what is actually running.
+	conf.ListenAddress = s.conf.ListenAddress
+	conf.HTTPAddress = s.conf.HTTPAddress
+	conf.HistorySize = s.conf.HistorySize
+	conf.Tracing = s.conf.Tracing
+	conf.Logging.Format = s.conf.Logging.Format
+	conf.Logging.Output = s.conf.Logging.Output
+	conf.Logging.Sampling = s.conf.Logging.Sampling
+	s.conf = conf
+
+	s.logger.Info("Configuration reloaded",
+		"masters_added", addedMasters,
+		"masters_removed", removedMasters,
+		"writers_added", addedWriters,
+		"writers_removed", removedWriters)
+
+	if addedMasters > 0 {
+		s.registerMasters()
+	}
+
+	return nil
+}
+
+func (s *Server) warnRestartRequired(conf *config.Config) {
+	if conf.ListenAddress.String() != s.conf.ListenAddress.String() {
+		s.logger.Warn("Changing listen_address requires a restart")
+	}
+
+	if conf.HTTPAddress != s.conf.HTTPAddress {
+		s.logger.Warn("Changing http_address requires a restart")
+	}
+
+	if conf.HistorySize != s.conf.HistorySize {
+		s.logger.Warn("Changing history_size requires a restart")
+	}
+
+	if !reflect.DeepEqual(conf.Tracing, s.conf.Tracing) {
+		s.logger.Warn("Changing tracing requires a restart")
+	}
+}
+
+func (s *Server) reloadMasters(addrs []*net.UDPAddr, opts []master.Options) (int, int) {
+	s.mastersMu.Lock()
+	defer s.mastersMu.Unlock()
+
+	keep := make(map[string]master.Options)
+	for i, addr := range addrs {
+		keep[addr.String()] = opts[i]
+	}
+
+	// Masters with changed settings are removed and added again, which
+	// registers them anew.
+	removed := 0
+	for key, e := range s.masters {
+		if o, ok := keep[key]; ok && o == e.opts {
+			continue
+		}
+
+		s.logger.Info("Removing master server", "master", key)
+		e.cancel()
+		if err := e.master.Close(); err != nil {
+			s.logger.Error("Failed to unregister from master server", "master", key, "error", err)
+		}
+		delete(s.masters, key)
+		removed++
+	}
+
+	added := 0
+	for i, addr := range addrs {
+		if s.addMaster(s.ctx, addr, opts[i]) {
+			s.logger.Info("Adding master server", "master", addr)
+			added++
+		}
+	}
+
+	return added, removed
+}
+
+func (s *Server) reloadWriters(conf *config.Config) (int, int) {
+	added, removed := s.swapWriters(conf)
+
+	// The removed writers are stopped before the new ones are started,
+	// since a changed writer may need resources held by its old instance,
+	// such as a listening port.
+	timeout := time.After(stopTimeout)
+	for _, e := range removed {
+		select {
+		case <-e.done:
+		case <-timeout:
+			s.logger.Warn("Timed out waiting for removed writers to stop")
+			timeout = nil
+		}
+	}
+
+	s.deleteRemovedSeries(removed)
+
+	for _, e := range added {
+		s.runWriter(s.ctx, e)
+	}
+
+	return len(added), len(removed)
+}
+
+// deleteRemovedSeries removes the metric series of the removed writers, unless
+// a remaining writer has taken over their labels.
+func (s *Server) deleteRemovedSeries(removed []*writerEntry) {
+	s.writersMu.RLock()
+	defer s.writersMu.RUnlock()
+
+	current := make(map[writerLabels]bool)
+	for _, e := range s.writers {
+		current[*e.labels.Load()] = true
+	}
+
+	for _, e := range removed {
+		if labels := e.labels.Load(); !current[*labels] {
+			deleteWriterSeries(labels)
+		}
+	}
+}
+
+// swapWriters replaces the writers with the ones in conf, keeping the running
+// writers with an unchanged spec. The removed writers are cancelled and the
+// added ones are returned to be started.
+func (s *Server) swapWriters(conf *config.Config) ([]*writerEntry, []*writerEntry) {
+	s.writersMu.Lock()
+	defer s.writersMu.Unlock()
+
+	// Index the running writers by spec, a spec can occur more than once
+	// if the same writer is configured multiple times.
+	running := make(map[string][]*writerEntry)
+	var internal []*writerEntry
+	for _, e := range s.writers {
+		if e.spec == "" {
+			internal = append(internal, e)
+			continue
+		}
+		running[e.spec] = append(running[e.spec], e)
+	}
+
+	var writers, added []*writerEntry
+	for i, w := range conf.Writers {
+		spec := conf.WriterSpecs[i]
+
+		if entries := running[spec]; len(entries) > 0 {
+			writers = append(writers, entries[0])
+			running[spec] = entries[1:]
+
+			// The writer is unchanged, so the new instance is discarded
+			// in favour of the running one.
+			w.Close()
+			continue
+		}
+
+		e := s.newWriterEntry(spec, conf.WriterFilters[i], w)
+		writers = append(writers, e)
+		added = append(added, e)
+	}
+
+	var removed []*writerEntry
+	for _, entries := range running {
+		for _, e := range entries {
+			e.cancel()
+			removed = append(removed, e)
+		}
+	}
+
+	s.writers = append(writers, internal...)
+	s.updateLabels()
+
+	return added, removed
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osm/qwbs/internal/config"
+	"github.com/osm/qwbs/internal/metrics"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+type nopWriter struct {
+	closed bool
+}
+
+func (w *nopWriter) Write(context.Context, *slog.Logger, *writer.Data) error {
+	return nil
+}
+
+func (w *nopWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func writersConfig(specs ...string) *config.Config {
+	conf := &config.Config{}
+	for _, spec := range specs {
+		conf.Writers = append(conf.Writers, &nopWriter{})
+		conf.WriterSpecs = append(conf.WriterSpecs, spec)
+		conf.WriterFilters = append(conf.WriterFilters, writer.Filter{})
+	}
+
+	return conf
+}
+
+func scrape(t *testing.T) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	metrics.Default.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	return rec.Body.String()
+}
+
+func TestReloadWriterLabels(t *testing.T) {
+	s := New(slog.New(slog.NewTextHandler(io.Discard, nil)), writersConfig("a", "b", "b"))
+	s.ctx = context.Background()
+	s.runWriters(s.ctx)
+
+	labels := func() []writerLabels {
+		var all []writerLabels
+		for _, e := range s.writers {
+			all = append(all, *e.labels.Load())
+		}
+		return all
+	}
+
+	before := labels()
+	if before[1].id == before[0].id || before[2].id != before[1].id+"-2" {
+		t.Fatalf("got labels %v, want distinct ids for every writer", before)
+	}
+
+	for _, l := range before {
+		observeWrite(&l, time.Now(), nil)
+	}
+
+	// Removing the first writer keeps the labels of the others and drops
+	// the series of the removed one.
+	conf := writersConfig("b", "b", "c")
+	s.reloadWriters(conf)
+
+	// The new instances of the unchanged writers are discarded.
+	for i, w := range conf.Writers {
+		if closed := w.(*nopWriter).closed; closed != (i < 2) {
+			t.Errorf("got writer %d closed %v, want %v", i, closed, i < 2)
+		}
+	}
+
+	after := labels()
+	if after[0] != before[1] || after[1] != before[2] {
+		t.Errorf("got labels %v after removing a writer, want %v to be kept", after, before[1:])
+	}
+
+	out := scrape(t)
+	if strings.Contains(out, `id="`+before[0].id+`"`) {
+		t.Errorf("got series of the removed writer %v", before[0])
+	}
+	if !strings.Contains(out, `id="`+before[1].id+`"`) {
+		t.Errorf("got no series of the kept writer %v", before[1])
+	}
+}
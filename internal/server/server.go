@@ -5,12 +5,19 @@ import (
 	"errors"
 	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/osm/qwbs/internal/config"
+	"github.com/osm/qwbs/internal/history"
+	"github.com/osm/qwbs/internal/logging"
 	"github.com/osm/qwbs/internal/qw/broadcast"
 	"github.com/osm/qwbs/internal/qw/command"
 	"github.com/osm/qwbs/internal/qw/master"
 	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/systemd"
+	"github.com/osm/qwbs/internal/tracing"
 	"github.com/osm/qwbs/internal/version"
 	"github.com/osm/qwbs/internal/writer"
 )
@@ -22,37 +29,94 @@ const (
 )
 
 type Server struct {
-	conn        *net.UDPConn
-	logger      *slog.Logger
-	listenAddr  *net.UDPAddr
-	masters     map[string]*master.Master
-	masterAddrs []*net.UDPAddr
-	writers     []writer.Writer
+	conn      *net.UDPConn
+	logger    *slog.Logger
+	loggers   map[string]*slog.Logger
+	conf      *config.Config
+	ctx       context.Context
+	reloadMu  sync.Mutex
+	masters   map[string]*masterEntry
+	mastersMu sync.RWMutex
+	writers   []*writerEntry
+	writersMu sync.RWMutex
+	lastRead  atomic.Int64
+	tracer    *tracing.Tracer
+	history   *history.History
+	wg        sync.WaitGroup
 }
 
-func New(
-	logger *slog.Logger,
-	listenAddr *net.UDPAddr,
-	masterAddrs []*net.UDPAddr,
-	writers []writer.Writer) *Server {
-	return &Server{
-		logger:      logger,
-		listenAddr:  listenAddr,
-		masters:     make(map[string]*master.Master),
-		masterAddrs: masterAddrs,
-		writers:     writers,
+type masterEntry struct {
+	master *master.Master
+	opts   master.Options
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// writerEntry is a running writer, spec is the writer configuration it was
+// created from and is empty for the internal writers.
+type writerEntry struct {
+	spec   string
+	writer writer.Writer
+	filter writer.Filter
+	labels atomic.Pointer[writerLabels]
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(logger *slog.Logger, conf *config.Config) *Server {
+	// Each component logs with its own logger so that their levels can be
+	// configured separately.
+	loggers := make(map[string]*slog.Logger)
+	for _, c := range logging.Components {
+		loggers[c] = logger.With(logging.ComponentKey, c)
+	}
+
+	s := &Server{
+		logger:  loggers[logging.ComponentServer],
+		loggers: loggers,
+		conf:    conf,
+		masters: make(map[string]*masterEntry),
+	}
+
+	for i, w := range conf.Writers {
+		s.writers = append(s.writers, s.newWriterEntry(conf.WriterSpecs[i], conf.WriterFilters[i], w))
+	}
+
+	if conf.HTTPAddress != "" {
+		s.history = history.New(conf.HistorySize)
+		s.writers = append(s.writers, s.newWriterEntry("", writer.Filter{}, s.history))
 	}
+
+	if conf.Tracing != nil {
+		s.tracer = tracing.New(*conf.Tracing)
+	}
+
+	s.updateLabels()
+	return s
 }
 
 func (s *Server) ListenAndServe(ctx context.Context) error {
-	conn, err := net.ListenUDP("udp", s.listenAddr)
+	conn, err := net.ListenUDP("udp", s.conf.ListenAddress)
 	if err != nil {
 		return err
 	}
 
+	s.reloadMu.Lock()
 	s.conn = conn
-	s.initMasters()
+	s.ctx = ctx
+	s.initMasters(ctx)
+	s.runWriters(ctx)
+	s.reloadMu.Unlock()
+
 	s.registerMasters()
+	s.runHTTP(ctx)
+	s.runWatchdog(ctx)
+	s.runTracer(ctx)
+
+	s.lastRead.Store(time.Now().UnixNano())
+	if err := systemd.Notify(systemd.Ready); err != nil {
+		s.logger.Error("Failed to notify systemd", "error", err)
+	}
 
 	buf := make([]byte, bufSize)
 	for {
@@ -62,9 +126,12 @@ func (s *Server) ListenAndServe(ctx context.Context) error {
 		}
 
 		n, clientAddr, err := conn.ReadFromUDP(buf)
+		s.lastRead.Store(time.Now().UnixNano())
 		if err != nil {
 			if ctx.Err() != nil {
 				s.logger.Info("Closing server")
+				systemd.Notify(systemd.Stopping)
+				s.wg.Wait()
 				return nil
 			}
 
@@ -80,9 +147,10 @@ func (s *Server) ListenAndServe(ctx context.Context) error {
 		}
 
 		cmd, payload := command.Parse(buf[:n])
+		packetsTotal.Inc(cmd.String())
 		switch cmd {
 		case command.ACK:
-			s.handleMasterACK(ctx, clientAddr)
+			s.handleMasterACK(clientAddr)
 		case command.Ping:
 			s.handlePing(clientAddr)
 		case command.GetChallenge:
@@ -92,40 +160,144 @@ func (s *Server) ListenAndServe(ctx context.Context) error {
 		case command.Broadcast:
 			s.handleBroadcast(ctx, clientAddr, payload)
 		default:
+			unknownPacketsTotal.Inc()
 			s.logger.Debug("Unexpected data received",
 				"client", clientAddr, "length", n)
 		}
 	}
 }
 
-func (s *Server) initMasters() {
-	for _, addr := range s.masterAddrs {
-		key := addr.String()
+func (s *Server) initMasters(ctx context.Context) {
+	s.mastersMu.Lock()
+	defer s.mastersMu.Unlock()
+
+	for i, addr := range s.conf.MasterAddresses {
+		s.addMaster(ctx, addr, s.conf.MasterOptions[i])
+	}
+}
 
-		if _, ok := s.masters[key]; ok {
+// addMaster adds a master server unless it's already known, the caller must
+// hold mastersMu.
+func (s *Server) addMaster(ctx context.Context, addr *net.UDPAddr, opts master.Options) bool {
+	key := addr.String()
+	if _, ok := s.masters[key]; ok {
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.masters[key] = &masterEntry{
+		master: master.New(s.conn, addr, opts, s.loggers[logging.ComponentMaster]),
+		opts:   opts,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	return true
+}
+
+func (s *Server) runWriters(ctx context.Context) {
+	s.writersMu.Lock()
+	defer s.writersMu.Unlock()
+
+	for _, e := range s.writers {
+		s.runWriter(ctx, e)
+	}
+}
+
+// runWriter starts the writer if it needs to run in the background. The
+// writer gets its own context so that it can be stopped on reload.
+func (s *Server) runWriter(ctx context.Context, e *writerEntry) {
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.done = make(chan struct{})
+
+	r, ok := e.writer.(writer.Runner)
+	if !ok {
+		close(e.done)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(e.done)
+		r.Run(ctx, s.loggers[logging.ComponentWriters])
+	}()
+}
+
+func (s *Server) newWriterEntry(spec string, filter writer.Filter, w writer.Writer) *writerEntry {
+	e := &writerEntry{spec: spec, writer: w, filter: filter}
+
+	// Writers that deliver in the background report the result once the
+	// broadcast has been delivered rather than when it's queued.
+	if q, ok := w.(writer.Queuer); ok {
+		q.SetDelivery(func(queued time.Time, err error) {
+			observeWrite(e.labels.Load(), queued, err)
+		})
+	}
+
+	return e
+}
+
+// updateLabels sets the metric labels from the writer specs. The labels are
+// stored atomically since they're read without holding the lock once a
+// broadcast has been dispatched.
+func (s *Server) updateLabels() {
+	seen := make(map[string]int)
+	for _, e := range s.writers {
+		seen[e.spec]++
+		labels := newWriterLabels(e.writer, e.spec, seen[e.spec])
+		e.labels.Store(&labels)
+	}
+}
+
+func (s *Server) runTracer(ctx context.Context) {
+	if s.tracer == nil {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.tracer.Run(ctx, s.logger)
+	}()
+}
+
+func (s *Server) Reopen() {
+	s.writersMu.RLock()
+	defer s.writersMu.RUnlock()
+
+	for _, e := range s.writers {
+		r, ok := e.writer.(writer.Reopener)
+		if !ok {
 			continue
 		}
 
-		s.masters[key] = master.New(s.conn, addr, s.logger)
+		if err := r.Reopen(); err != nil {
+			s.logger.Error("Failed to reopen writer", "error", err)
+		}
 	}
 }
 
 func (s *Server) registerMasters() {
-	for _, m := range s.masters {
-		if err := m.Register(); err != nil {
+	s.mastersMu.RLock()
+	defer s.mastersMu.RUnlock()
+
+	for _, e := range s.masters {
+		if err := e.master.Register(); err != nil {
 			s.logger.Error("Failed to register master", "error", err)
 		}
 	}
 }
 
-func (s *Server) handleMasterACK(ctx context.Context, clientAddr *net.UDPAddr) {
-	m, ok := s.masters[clientAddr.String()]
+func (s *Server) handleMasterACK(clientAddr *net.UDPAddr) {
+	s.mastersMu.RLock()
+	e, ok := s.masters[clientAddr.String()]
+	s.mastersMu.RUnlock()
 	if !ok {
 		s.logger.Error("Unexpected ACK received", "client", clientAddr)
 		return
 	}
 
-	go m.Heartbeat(ctx)
+	go e.master.Heartbeat(e.ctx)
 }
 
 func (s *Server) handlePing(clientAddr *net.UDPAddr) {
@@ -157,19 +329,93 @@ func (s *Server) handleStatus(clientAddr *net.UDPAddr) {
 }
 
 func (s *Server) handleBroadcast(ctx context.Context, clientAddr *net.UDPAddr, payload []byte) {
+	ctx, span := s.tracer.Start(ctx, "broadcast", tracing.KindServer)
+	span.SetAttr("client.address", clientAddr.String())
+
 	bc, err := broadcast.Parse(clientAddr, payload)
 	if err != nil {
+		broadcastsTotal.Inc("failure")
+		span.SetError(err)
+		span.End()
 		s.logger.Error("Failed to parse broadcast", "error", err)
 		return
 	}
+	broadcastsTotal.Inc("success")
+	span.SetAttr("qw.server.address", bc.Address)
 
-	sd, err := serverstatus.Query(bc.Address)
+	sd, err := s.queryStatus(ctx, bc.Address)
 	if err != nil {
-		s.logger.Error("Failed to get server status", "error", err)
+		span.SetError(err)
+		span.End()
+		s.loggers[logging.ComponentServerStatus].Error("Failed to get server status", "error", err)
 		return
 	}
 
-	for _, w := range s.writers {
-		go w.Write(ctx, s.logger, &writer.Data{Broadcast: bc, Server: sd})
+	var wg sync.WaitGroup
+	now := time.Now()
+	s.writersMu.RLock()
+	for _, e := range s.writers {
+		data := &writer.Data{Broadcast: bc, Server: sd, Time: now}
+		if !e.filter.Match(data) {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.write(ctx, e, data)
+		}()
+	}
+	s.writersMu.RUnlock()
+
+	// The writer spans are children of the broadcast span, so it's ended
+	// once they're done rather than when the writes have been started.
+	go func() {
+		wg.Wait()
+		span.End()
+	}()
+}
+
+func (s *Server) queryStatus(ctx context.Context, address string) (*serverstatus.Server, error) {
+	_, span := s.tracer.Start(ctx, "serverstatus.Query", tracing.KindClient)
+	defer span.End()
+	span.SetAttr("qw.server.address", address)
+
+	start := time.Now()
+	sd, err := serverstatus.Query(address)
+	statusQueryDuration.Observe(time.Since(start).Seconds())
+	statusQueriesTotal.Inc(result(err))
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+
+	return sd, nil
+}
+
+func (s *Server) write(ctx context.Context, e *writerEntry, data *writer.Data) {
+	writersInFlight.Inc()
+	defer writersInFlight.Dec()
+
+	labels := e.labels.Load()
+
+	ctx, span := s.tracer.Start(ctx, "writer.Write", tracing.KindInternal)
+	defer span.End()
+	span.SetAttr("writer.type", labels.name)
+	span.SetAttr("writer.id", labels.id)
+
+	logger := s.loggers[logging.ComponentWriters]
+
+	start := time.Now()
+	err := e.writer.Write(ctx, logger, data)
+	if err != nil {
+		span.SetError(err)
+		logger.Error("Failed to write broadcast",
+			"writer", labels.name, "id", labels.id, "error", err)
+	}
+
+	if _, ok := e.writer.(writer.Queuer); ok && err == nil {
+		return
 	}
+	observeWrite(labels, start, err)
 }
@@ -0,0 +1,243 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/osm/qwbs/internal/version"
+)
+
+const (
+	DefaultServiceName = "qwbs"
+)
+
+const (
+	tracesPath     = "/v1/traces"
+	queueSize      = 1024
+	batchSize      = 256
+	flushInterval  = time.Second * 5
+	exportTimeout  = time.Second * 10
+	scopeName      = "github.com/osm/qwbs"
+	maxErrorLength = 512
+)
+
+type Options struct {
+	Endpoint    string
+	ServiceName string
+	Header      http.Header
+}
+
+// Tracer collects ended spans and exports them in batches to an OpenTelemetry
+// collector using OTLP over HTTP with JSON encoding.
+type Tracer struct {
+	opts   Options
+	url    string
+	client *http.Client
+	queue  chan *Span
+}
+
+func New(opts Options) *Tracer {
+	if opts.ServiceName == "" {
+		opts.ServiceName = DefaultServiceName
+	}
+
+	return &Tracer{
+		opts:   opts,
+		url:    strings.TrimSuffix(opts.Endpoint, "/") + tracesPath,
+		client: &http.Client{Timeout: exportTimeout},
+		queue:  make(chan *Span, queueSize),
+	}
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		// Tracing is best effort, drop the span rather than blocking the
+		// broadcast pipeline.
+	}
+}
+
+func (t *Tracer) Run(ctx context.Context, logger *slog.Logger) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := t.export(batch); err != nil {
+			logger.Error("Failed to export spans", "endpoint", t.url, "spans", len(batch), "error", err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (t *Tracer) export(spans []*Span) error {
+	body, err := json.Marshal(t.newRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range t.opts.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+		return fmt.Errorf("received an unexpected response: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanData `json:"spans"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type spanData struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              Kind       `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            status     `json:"status"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (t *Tracer) newRequest(spans []*Span) *exportRequest {
+	data := make([]spanData, 0, len(spans))
+	for _, s := range spans {
+		data = append(data, s.data())
+	}
+
+	return &exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{Attributes: []keyValue{
+				{Key: "service.name", Value: anyValue{StringValue: t.opts.ServiceName}},
+				{Key: "service.version", Value: anyValue{StringValue: version.Short()}},
+			}},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: scopeName, Version: version.Short()},
+				Spans: data,
+			}},
+		}},
+	}
+}
+
+func (s *Span) data() spanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := spanData{
+		TraceID:           s.traceID.String(),
+		SpanID:            s.spanID.String(),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+
+	if s.parentID != (SpanID{}) {
+		d.ParentSpanID = s.parentID.String()
+	}
+
+	if s.status == statusError {
+		d.Status = status{Code: statusError, Message: s.errMsg}
+	}
+
+	keys := make([]string, 0, len(s.attrs))
+	for k := range s.attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		d.Attributes = append(d.Attributes, keyValue{Key: k, Value: anyValue{StringValue: s.attrs[k]}})
+	}
+
+	return d
+}
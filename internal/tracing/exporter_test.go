@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// collector is a local OTLP/HTTP endpoint that records the export requests.
+type collector struct {
+	*httptest.Server
+	requests chan *http.Request
+	bodies   chan *exportRequest
+}
+
+func newCollector(t *testing.T, statusCode int) *collector {
+	c := &collector{
+		requests: make(chan *http.Request, 10),
+		bodies:   make(chan *exportRequest, 10),
+	}
+
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req exportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode export request: %v", err)
+		}
+
+		c.requests <- r
+		c.bodies <- &req
+		w.WriteHeader(statusCode)
+	}))
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+func TestExport(t *testing.T) {
+	c := newCollector(t, http.StatusOK)
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer secret")
+	tracer := New(Options{Endpoint: c.URL + "/", ServiceName: "qwbs-test", Header: header})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tracer.Run(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		close(done)
+	}()
+
+	rootCtx, root := tracer.Start(context.Background(), "broadcast", KindServer)
+	root.SetAttr("client.address", "127.0.0.1:27500")
+
+	_, child := tracer.Start(rootCtx, "writer.Write", KindInternal)
+	child.SetError(errors.New("connection refused"))
+	child.End()
+	root.End()
+	root.End()
+
+	// Spans are exported when the tracer is stopped.
+	cancel()
+	<-done
+
+	r := <-c.requests
+	if r.Method != http.MethodPost || r.URL.Path != tracesPath {
+		t.Errorf("got %s %s, want POST %s", r.Method, r.URL.Path, tracesPath)
+	}
+	if got := r.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("got content type %q, want application/json", got)
+	}
+	if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("got authorization %q, want the configured header", got)
+	}
+
+	req := <-c.bodies
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("got %d resource spans, want a single resource and scope", len(req.ResourceSpans))
+	}
+
+	rs := req.ResourceSpans[0]
+	if attr := rs.Resource.Attributes[0]; attr.Key != "service.name" || attr.Value.StringValue != "qwbs-test" {
+		t.Errorf("got resource attribute %+v, want service.name qwbs-test", attr)
+	}
+
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+
+	gotChild, gotRoot := spans[0], spans[1]
+	if gotRoot.Name != "broadcast" || gotRoot.Kind != KindServer || gotRoot.ParentSpanID != "" {
+		t.Errorf("got root span %+v", gotRoot)
+	}
+	if len(gotRoot.Attributes) != 1 || gotRoot.Attributes[0].Value.StringValue != "127.0.0.1:27500" {
+		t.Errorf("got root attributes %+v", gotRoot.Attributes)
+	}
+
+	if gotChild.TraceID != gotRoot.TraceID || gotChild.ParentSpanID != gotRoot.SpanID {
+		t.Errorf("child span %+v is not a child of root span %+v", gotChild, gotRoot)
+	}
+	if gotChild.Status.Code != statusError || gotChild.Status.Message != "connection refused" {
+		t.Errorf("got child status %+v, want error", gotChild.Status)
+	}
+}
+
+func TestExportError(t *testing.T) {
+	c := newCollector(t, http.StatusBadRequest)
+	tracer := New(Options{Endpoint: c.URL})
+
+	_, span := tracer.Start(context.Background(), "broadcast", KindServer)
+	span.End()
+
+	if err := tracer.export([]*Span{span}); err == nil {
+		t.Error("got no error for a rejected export")
+	}
+}
+
+func TestInject(t *testing.T) {
+	tracer := New(Options{Endpoint: "http://localhost:4318"})
+	ctx, span := tracer.Start(context.Background(), "broadcast", KindServer)
+
+	header := make(http.Header)
+	Inject(ctx, header)
+
+	want := "00-" + span.traceID.String() + "-" + span.spanID.String() + "-01"
+	if got := header.Get(traceparentHeader); got != want {
+		t.Errorf("got traceparent %q, want %q", got, want)
+	}
+
+	header = make(http.Header)
+	Inject(context.Background(), header)
+	if got := header.Get(traceparentHeader); got != "" {
+		t.Errorf("got traceparent %q without a span", got)
+	}
+}
@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+const (
+	statusError = 2
+)
+
+const (
+	traceparentHeader = "traceparent"
+)
+
+type TraceID [16]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+type SpanID [8]byte
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+type Span struct {
+	tracer   *Tracer
+	traceID  TraceID
+	spanID   SpanID
+	parentID SpanID
+	name     string
+	kind     Kind
+	start    time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  map[string]string
+	status int
+	errMsg string
+	ended  bool
+}
+
+type spanKey struct{}
+
+// Start creates a new span as a child of the span in ctx, if any, and
+// returns a context carrying the new span. A nil tracer returns a nil span,
+// all span methods are safe to call on nil.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  make(map[string]string),
+	}
+	rand.Read(s.spanID[:])
+
+	if parent := FromContext(ctx); parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+func (s *Span) SetAttr(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attrs[key] = value
+}
+
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = statusError
+	s.errMsg = err.Error()
+}
+
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	s.tracer.enqueue(s)
+}
+
+// Inject adds a W3C traceparent header for the span in ctx so that the
+// receiver can continue the trace.
+func Inject(ctx context.Context, header http.Header) {
+	s := FromContext(ctx)
+	if s == nil {
+		return
+	}
+
+	header.Set(traceparentHeader, "00-"+s.traceID.String()+"-"+s.spanID.String()+"-01")
+}
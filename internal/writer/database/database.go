@@ -0,0 +1,370 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+
+	"github.com/osm/qwbs/internal/writer"
+)
+
+const (
+	DefaultBatchSize     = 50
+	DefaultFlushInterval = time.Second * 5
+)
+
+const (
+	queueSize    = 1000
+	flushTimeout = time.Second * 30
+	checkTimeout = time.Second * 5
+	minBackoff   = time.Second
+	maxBackoff   = time.Minute * 5
+	maxAttempts  = 5
+)
+
+type dialect struct {
+	driver    string
+	serial    string
+	timestamp string
+	numbered  bool
+}
+
+var dialects = map[string]*dialect{
+	"sqlite": {
+		driver:    "sqlite",
+		serial:    "INTEGER",
+		timestamp: "TIMESTAMP",
+	},
+	"postgres": {
+		driver:    "postgres",
+		serial:    "BIGSERIAL",
+		timestamp: "TIMESTAMPTZ",
+		numbered:  true,
+	},
+}
+
+func (d *dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+type Options struct {
+	Driver        string
+	DSN           string
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+type Database struct {
+	opts     Options
+	dialect  *dialect
+	db       *sql.DB
+	queue    chan *item
+	delivery writer.Delivery
+}
+
+type item struct {
+	data   *writer.Data
+	queued time.Time
+}
+
+func New(opts Options) (*Database, error) {
+	d, ok := dialects[opts.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown database driver %q", opts.Driver)
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+
+	// Opening a database only validates the arguments, the connection is
+	// established on first use.
+	db, err := sql.Open(d.driver, opts.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if opts.Driver == "sqlite" {
+		db.SetMaxOpenConns(1)
+	}
+
+	return &Database{
+		opts:     opts,
+		dialect:  d,
+		db:       db,
+		queue:    make(chan *item, queueSize),
+		delivery: func(time.Time, error) {},
+	}, nil
+}
+
+func (d *Database) Write(_ context.Context, _ *slog.Logger, data *writer.Data) error {
+	select {
+	case d.queue <- &item{data: data, queued: time.Now()}:
+		return nil
+	default:
+		return fmt.Errorf("database queue is full, dropping broadcast from %s", data.Broadcast.Address)
+	}
+}
+
+// SetDelivery sets the function that is called when a queued broadcast has
+// been inserted or dropped.
+func (d *Database) SetDelivery(delivery writer.Delivery) {
+	d.delivery = delivery
+}
+
+// Close releases the database handle of a writer that was never run.
+func (d *Database) Close() error {
+	return d.db.Close()
+}
+
+func (d *Database) Check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	return d.db.PingContext(ctx)
+}
+
+func (d *Database) Run(ctx context.Context, logger *slog.Logger) {
+	defer d.db.Close()
+
+	if !d.setup(ctx, logger) {
+		if batch := d.drain(); len(batch) > 0 {
+			logger.Error("Database was never set up, dropping broadcasts", "count", len(batch))
+			d.report(batch, errors.New("database was never set up"))
+		}
+		return
+	}
+
+	ticker := time.NewTicker(d.opts.FlushInterval)
+	defer ticker.Stop()
+
+	// A batch that failed to be inserted is kept and retried on the next
+	// tick, new broadcasts are added to it in the meantime.
+	var batch []*item
+	attempts := 0
+	for {
+		select {
+		case it := <-d.queue:
+			batch = append(batch, it)
+			if len(batch) < d.opts.BatchSize || attempts > 0 {
+				continue
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			batch = append(batch, d.drain()...)
+			err := d.flush(batch)
+			if err != nil {
+				logger.Error("Failed to insert broadcasts, dropping them",
+					"count", len(batch), "error", err)
+			}
+			d.report(batch, err)
+			return
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		err := d.flush(batch)
+		if err == nil {
+			d.report(batch, nil)
+			batch = batch[:0]
+			attempts = 0
+			continue
+		}
+
+		attempts++
+		if attempts >= maxAttempts {
+			logger.Error("Failed to insert broadcasts, dropping them",
+				"count", len(batch), "attempts", attempts, "error", err)
+			d.report(batch, err)
+			batch = batch[:0]
+			attempts = 0
+			continue
+		}
+
+		if n := len(batch) - queueSize; n > 0 {
+			logger.Error("Too many broadcasts pending insert, dropping the oldest", "count", n)
+			d.report(batch[:n], err)
+			batch = batch[n:]
+		}
+
+		logger.Error("Failed to insert broadcasts, retrying",
+			"count", len(batch), "attempts", attempts, "error", err)
+	}
+}
+
+// setup prepares and migrates the database, retrying with backoff until it
+// succeeds since the database may not be available yet when the service is
+// started.
+func (d *Database) setup(ctx context.Context, logger *slog.Logger) bool {
+	backoff := minBackoff
+
+	for {
+		n, err := d.prepare(ctx)
+		if err == nil {
+			if n > 0 {
+				logger.Info("Migrated database", "driver", d.opts.Driver, "migrations", n)
+			}
+			return true
+		}
+
+		if ctx.Err() != nil {
+			return false
+		}
+
+		logger.Error("Failed to migrate database, retrying",
+			"driver", d.opts.Driver, "error", err, "backoff", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return false
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (d *Database) prepare(ctx context.Context) (int, error) {
+	if d.opts.Driver == "sqlite" {
+		if _, err := d.db.ExecContext(ctx, "PRAGMA foreign_keys = ON"); err != nil {
+			return 0, fmt.Errorf("failed to enable foreign keys: %w", err)
+		}
+	}
+
+	return d.migrate(ctx)
+}
+
+func (d *Database) report(batch []*item, err error) {
+	for _, it := range batch {
+		d.delivery(it.queued, err)
+	}
+}
+
+func (d *Database) drain() []*item {
+	var batch []*item
+	for {
+		select {
+		case it := <-d.queue:
+			batch = append(batch, it)
+		default:
+			return batch
+		}
+	}
+}
+
+func (d *Database) flush(batch []*item) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// Use a fresh context so that pending broadcasts are stored on shutdown.
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	return d.insert(ctx, batch)
+}
+
+func (d *Database) insert(ctx context.Context, batch []*item) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsertServer, err := tx.PrepareContext(ctx, d.dialect.rebind(`
+		INSERT INTO servers (address, first_seen, last_seen) VALUES (?, ?, ?)
+		ON CONFLICT (address) DO UPDATE SET last_seen = excluded.last_seen
+		RETURNING id`))
+	if err != nil {
+		return err
+	}
+	defer upsertServer.Close()
+
+	insertBroadcast, err := tx.PrepareContext(ctx, d.dialect.rebind(`
+		INSERT INTO broadcasts
+			(server_id, received_at, name, message, map, mode, players, max_players)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`))
+	if err != nil {
+		return err
+	}
+	defer insertBroadcast.Close()
+
+	insertPlayer, err := tx.PrepareContext(ctx, d.dialect.rebind(`
+		INSERT INTO players (broadcast_id, name, team, spectator) VALUES (?, ?, ?, ?)`))
+	if err != nil {
+		return err
+	}
+	defer insertPlayer.Close()
+
+	for _, it := range batch {
+		data := it.data
+		bc := data.Broadcast
+		sv := data.Server
+
+		t := data.Time.UTC()
+		if data.Time.IsZero() {
+			t = time.Now().UTC()
+		}
+
+		var serverID int64
+		if err := upsertServer.QueryRowContext(ctx, bc.Address, t, t).Scan(&serverID); err != nil {
+			return fmt.Errorf("failed to upsert server %q: %w", bc.Address, err)
+		}
+
+		var broadcastID int64
+		err := insertBroadcast.QueryRowContext(ctx,
+			serverID, t, bc.Name, bc.Message, sv.Map, sv.Mode,
+			nullInt(data.Players()), nullInt(data.MaxPlayers()),
+		).Scan(&broadcastID)
+		if err != nil {
+			return fmt.Errorf("failed to insert broadcast: %w", err)
+		}
+
+		for _, p := range sv.Players {
+			if _, err := insertPlayer.ExecContext(ctx, broadcastID, p.Name, p.Team, p.Spectator); err != nil {
+				return fmt.Errorf("failed to insert player: %w", err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func nullInt(s string) sql.NullInt64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: n, Valid: true}
+}
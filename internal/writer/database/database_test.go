@@ -0,0 +1,239 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/osm/qwbs/internal/qw/broadcast"
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+const testTimeout = time.Second * 5
+
+func newDatabase(t *testing.T, dsn string) *Database {
+	t.Helper()
+
+	d, err := New(Options{Driver: "sqlite", DSN: dsn, BatchSize: 2, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	return d
+}
+
+func testData(address, players string) *writer.Data {
+	return &writer.Data{
+		Broadcast: &broadcast.Broadcast{
+			Address:    address,
+			MaxPlayers: "8",
+			Message:    "need 2 more",
+			Name:       "Bob",
+			Players:    players,
+		},
+		Server: &serverstatus.Server{
+			Map:  "dm3",
+			Mode: "2on2",
+			Players: []serverstatus.Player{
+				{Name: "Bob", Team: "red"},
+				{Name: "Alice", Team: "blue"},
+				{Name: "Eve", Spectator: true},
+			},
+		},
+		Time: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func count(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatalf("failed to count %s: %v", table, err)
+	}
+
+	return n
+}
+
+func TestMigrate(t *testing.T) {
+	d := newDatabase(t, filepath.Join(t.TempDir(), "qwbs.db"))
+	ctx := context.Background()
+
+	n, err := d.prepare(ctx)
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if n != len(migrations) {
+		t.Errorf("got %d migrations applied, want %d", n, len(migrations))
+	}
+
+	// Migrations that have been applied are skipped.
+	if n, err := d.prepare(ctx); err != nil || n != 0 {
+		t.Errorf("got %d migrations applied and error %v the second time, want none", n, err)
+	}
+
+	var version int
+	if err := d.db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		t.Fatalf("failed to read schema version: %v", err)
+	}
+	if version != len(migrations) {
+		t.Errorf("got schema version %d, want %d", version, len(migrations))
+	}
+
+	for _, table := range []string{"servers", "broadcasts", "players"} {
+		if n := count(t, d.db, table); n != 0 {
+			t.Errorf("got %d rows in %s, want none", n, table)
+		}
+	}
+}
+
+func TestInsert(t *testing.T) {
+	d := newDatabase(t, filepath.Join(t.TempDir(), "qwbs.db"))
+	ctx := context.Background()
+
+	if _, err := d.prepare(ctx); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	batch := []*item{
+		{data: testData("qw.example.com:28501", "2")},
+		{data: testData("qw.example.com:28501", "unknown")},
+		{data: testData("qw.example.com:28502", "x")},
+	}
+	if err := d.insert(ctx, batch); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if n := count(t, d.db, "servers"); n != 2 {
+		t.Errorf("got %d servers, want 2", n)
+	}
+	if n := count(t, d.db, "broadcasts"); n != 3 {
+		t.Errorf("got %d broadcasts, want 3", n)
+	}
+	if n := count(t, d.db, "players"); n != 9 {
+		t.Errorf("got %d players, want 9", n)
+	}
+
+	rows, err := d.db.Query(`
+		SELECT s.address, b.map, b.mode, b.players, b.max_players
+		FROM broadcasts b JOIN servers s ON s.id = b.server_id
+		ORDER BY b.id`)
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	defer rows.Close()
+
+	// Unknown player counts are taken from the server status, and counts
+	// that aren't numbers are stored as NULL.
+	want := []sql.NullInt64{{Int64: 2, Valid: true}, {Int64: 2, Valid: true}, {}}
+	for i := 0; rows.Next(); i++ {
+		var address, mapName, mode string
+		var players, maxPlayers sql.NullInt64
+		if err := rows.Scan(&address, &mapName, &mode, &players, &maxPlayers); err != nil {
+			t.Fatalf("got error %v", err)
+		}
+
+		if address != batch[i].data.Broadcast.Address || mapName != "dm3" || mode != "2on2" {
+			t.Errorf("got broadcast %d from %s on %s %s", i, address, mapName, mode)
+		}
+		if players != want[i] {
+			t.Errorf("got players %v for broadcast %d, want %v", players, i, want[i])
+		}
+		if maxPlayers.Int64 != 8 {
+			t.Errorf("got max players %v for broadcast %d, want 8", maxPlayers, i)
+		}
+	}
+
+	var spectators int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM players WHERE spectator").Scan(&spectators); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if spectators != 3 {
+		t.Errorf("got %d spectators, want 3", spectators)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "qwbs.db")
+	d := newDatabase(t, dsn)
+
+	delivered := make(chan error, 10)
+	d.SetDelivery(func(_ time.Time, err error) {
+		delivered <- err
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		close(done)
+	}()
+
+	// A full batch is inserted at once and the rest on shutdown.
+	for _, address := range []string{"qw.example.com:28501", "qw.example.com:28502", "qw.example.com:28503"} {
+		if err := d.Write(ctx, nil, testData(address, "2")); err != nil {
+			t.Fatalf("got error %v", err)
+		}
+	}
+
+	for range 2 {
+		select {
+		case err := <-delivered:
+			if err != nil {
+				t.Errorf("got delivery error %v", err)
+			}
+		case <-time.After(testTimeout):
+			t.Fatal("timed out waiting for the batch to be inserted")
+		}
+	}
+
+	cancel()
+	<-done
+
+	if err := <-delivered; err != nil {
+		t.Errorf("got delivery error %v on shutdown", err)
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	defer db.Close()
+
+	if n := count(t, db, "broadcasts"); n != 3 {
+		t.Errorf("got %d broadcasts, want 3", n)
+	}
+}
+
+func TestRunNeverSetUp(t *testing.T) {
+	// The directory doesn't exist, so the database can't be created.
+	d := newDatabase(t, filepath.Join(t.TempDir(), "missing", "qwbs.db"))
+
+	delivered := make(chan error, 10)
+	d.SetDelivery(func(_ time.Time, err error) {
+		delivered <- err
+	})
+
+	if err := d.Write(context.Background(), nil, testData("qw.example.com:28501", "2")); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	d.Run(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	select {
+	case err := <-delivered:
+		if err == nil {
+			t.Error("got no delivery error for a broadcast that was never inserted")
+		}
+	default:
+		t.Error("got no delivery for a broadcast that was never inserted")
+	}
+}
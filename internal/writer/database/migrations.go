@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var migrations = []string{
+	`CREATE TABLE servers (
+		id {serial} PRIMARY KEY,
+		address TEXT NOT NULL UNIQUE,
+		first_seen {timestamp} NOT NULL,
+		last_seen {timestamp} NOT NULL
+	);
+	CREATE TABLE broadcasts (
+		id {serial} PRIMARY KEY,
+		server_id BIGINT NOT NULL REFERENCES servers (id),
+		received_at {timestamp} NOT NULL,
+		name TEXT NOT NULL,
+		message TEXT NOT NULL,
+		map TEXT NOT NULL,
+		mode TEXT NOT NULL,
+		players INTEGER,
+		max_players INTEGER
+	);
+	CREATE INDEX broadcasts_server_id_idx ON broadcasts (server_id);
+	CREATE INDEX broadcasts_received_at_idx ON broadcasts (received_at);
+	CREATE TABLE players (
+		id {serial} PRIMARY KEY,
+		broadcast_id BIGINT NOT NULL REFERENCES broadcasts (id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		team TEXT NOT NULL,
+		spectator BOOLEAN NOT NULL
+	);
+	CREATE INDEX players_broadcast_id_idx ON players (broadcast_id);
+	CREATE INDEX players_name_idx ON players (name);`,
+}
+
+func (d *Database) migrate(ctx context.Context) (int, error) {
+	_, err := d.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at `+d.dialect.timestamp+` NOT NULL
+	)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	var current int
+	row := d.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)
+	if err := row.Scan(&current); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	applied := 0
+	for i := current; i < len(migrations); i++ {
+		if err := d.applyMigration(ctx, i+1, migrations[i]); err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+		applied++
+	}
+
+	return applied, nil
+}
+
+func (d *Database) applyMigration(ctx context.Context, version int, migration string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	migration = strings.NewReplacer(
+		"{serial}", d.dialect.serial,
+		"{timestamp}", d.dialect.timestamp,
+	).Replace(migration)
+
+	// Not every driver supports multiple statements in a single Exec.
+	for _, stmt := range strings.Split(migration, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		d.dialect.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`),
+		version, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,419 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/osm/qwbs/internal/qw/charset"
+	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/textfmt"
+)
+
+const (
+	DefaultBurst = 4
+	DefaultRate  = time.Second * 2
+)
+
+const (
+	dialTimeout    = time.Second * 30
+	readTimeout    = time.Minute * 4
+	writeTimeout   = time.Second * 30
+	minBackoff     = time.Second * 5
+	maxBackoff     = time.Minute * 5
+	queueSize      = 100
+	maxLineLength  = 400
+	quitMessage    = "QUIT :Shutting down"
+	colorCode      = "\x03"
+	colorBrown     = "05"
+	colorGold      = "08"
+	resetCode      = "\x0f"
+	replyWelcome   = "001"
+	errNicknameUse = "433"
+)
+
+// rejoinDelay is how long to wait before rejoining a channel after the first
+// kick, it's a variable so that tests can shorten it.
+var rejoinDelay = time.Second * 5
+
+type Options struct {
+	Address  string
+	TLS      bool
+	Nick     string
+	User     string
+	RealName string
+	Password string
+	Channels []string
+	Rate     time.Duration
+	Burst    int
+}
+
+type IRC struct {
+	opts      Options
+	dial      func(ctx context.Context) (net.Conn, error)
+	queue     chan *message
+	delivery  writer.Delivery
+	connected atomic.Bool
+}
+
+type message struct {
+	line   string
+	queued time.Time
+}
+
+type kick struct {
+	delay time.Duration
+	last  time.Time
+}
+
+type session struct {
+	conn    net.Conn
+	mu      sync.Mutex
+	nick    string
+	ready   chan struct{}
+	welcome sync.Once
+	kicks   map[string]*kick
+	pinged  bool
+	limiter *limiter
+}
+
+func New(opts Options) *IRC {
+	if opts.User == "" {
+		opts.User = opts.Nick
+	}
+
+	if opts.RealName == "" {
+		opts.RealName = opts.Nick
+	}
+
+	if opts.Rate <= 0 {
+		opts.Rate = DefaultRate
+	}
+
+	if opts.Burst <= 0 {
+		opts.Burst = DefaultBurst
+	}
+
+	i := &IRC{
+		opts:     opts,
+		queue:    make(chan *message, queueSize),
+		delivery: func(time.Time, error) {},
+	}
+	i.dial = i.dialServer
+
+	return i
+}
+
+func (i *IRC) Write(_ context.Context, _ *slog.Logger, data *writer.Data) error {
+	text := format(data)
+	now := time.Now()
+
+	var errs []error
+	for _, channel := range i.opts.Channels {
+		select {
+		case i.queue <- &message{line: fmt.Sprintf("PRIVMSG %s :%s", channel, text), queued: now}:
+		default:
+			errs = append(errs, fmt.Errorf("IRC queue is full, dropping message to %s", channel))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SetDelivery sets the function that is called when a queued message has
+// been sent or failed to be sent.
+func (i *IRC) SetDelivery(delivery writer.Delivery) {
+	i.delivery = delivery
+}
+
+// Close does nothing, the connection is only made and closed by Run.
+func (i *IRC) Close() error {
+	return nil
+}
+
+func (i *IRC) Check() error {
+	if !i.connected.Load() {
+		return errors.New("not connected to IRC server")
+	}
+
+	return nil
+}
+
+func (i *IRC) Run(ctx context.Context, logger *slog.Logger) {
+	backoff := minBackoff
+
+	for {
+		start := time.Now()
+		err := i.connect(ctx, logger)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+
+		logger.Error("IRC connection lost, reconnecting",
+			"server", i.opts.Address, "error", err, "backoff", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (i *IRC) dialServer(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if !i.opts.TLS {
+		return dialer.DialContext(ctx, "tcp", i.opts.Address)
+	}
+
+	host, _, _ := net.SplitHostPort(i.opts.Address)
+	return (&tls.Dialer{
+		NetDialer: dialer,
+		Config:    &tls.Config{ServerName: host},
+	}).DialContext(ctx, "tcp", i.opts.Address)
+}
+
+func (i *IRC) connect(ctx context.Context, logger *slog.Logger) error {
+	conn, err := i.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	logger.Info("Connected to IRC server", "server", i.opts.Address)
+
+	s := &session{
+		conn:    conn,
+		nick:    i.opts.Nick,
+		ready:   make(chan struct{}),
+		kicks:   make(map[string]*kick),
+		limiter: newLimiter(i.opts.Rate, i.opts.Burst),
+	}
+
+	if i.opts.Password != "" {
+		if err := s.send("PASS " + i.opts.Password); err != nil {
+			return err
+		}
+	}
+
+	if err := s.send("NICK " + s.nick); err != nil {
+		return err
+	}
+
+	if err := s.send(fmt.Sprintf("USER %s 0 * :%s", i.opts.User, i.opts.RealName)); err != nil {
+		return err
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- i.read(logger, s)
+	}()
+
+	select {
+	case <-s.ready:
+		i.connected.Store(true)
+		defer i.connected.Store(false)
+	case err := <-readErr:
+		return err
+	case <-ctx.Done():
+		s.send(quitMessage)
+		return ctx.Err()
+	}
+
+	for {
+		select {
+		case msg := <-i.queue:
+			if err := s.limiter.wait(ctx); err != nil {
+				i.delivery(msg.queued, err)
+				s.send(quitMessage)
+				return err
+			}
+
+			err := s.send(msg.line)
+			i.delivery(msg.queued, err)
+			if err != nil {
+				return err
+			}
+		case err := <-readErr:
+			return err
+		case <-ctx.Done():
+			s.send(quitMessage)
+			return ctx.Err()
+		}
+	}
+}
+
+func (i *IRC) read(logger *slog.Logger, s *session) error {
+	r := bufio.NewReader(s.conn)
+
+	for {
+		if err := s.conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+			return err
+		}
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && !s.pinged {
+				s.pinged = true
+				if err := s.send("PING :" + s.nick); err != nil {
+					return err
+				}
+				continue
+			}
+
+			return err
+		}
+		s.pinged = false
+
+		prefix, cmd, params := parse(strings.TrimRight(line, "\r\n"))
+		switch cmd {
+		case "PING":
+			if err := s.send("PONG :" + last(params)); err != nil {
+				return err
+			}
+		case replyWelcome:
+			for _, channel := range i.opts.Channels {
+				if err := s.send("JOIN " + channel); err != nil {
+					return err
+				}
+			}
+			logger.Info("Registered with IRC server",
+				"server", i.opts.Address, "nick", s.nick)
+
+			// Some servers and bouncers send the welcome more than once.
+			s.welcome.Do(func() {
+				close(s.ready)
+			})
+		case errNicknameUse:
+			s.nick += "_"
+			if err := s.send("NICK " + s.nick); err != nil {
+				return err
+			}
+		case "KICK":
+			if len(params) >= 2 && params[1] == s.nick {
+				delay := s.rejoinDelay(params[0])
+				logger.Error("Kicked from IRC channel",
+					"channel", params[0], "by", prefix, "reason", last(params), "rejoin", delay)
+
+				channel := params[0]
+				time.AfterFunc(delay, func() {
+					s.send("JOIN " + channel)
+				})
+			}
+		case "ERROR":
+			return fmt.Errorf("server error: %s", last(params))
+		}
+	}
+}
+
+// rejoinDelay returns how long to wait before rejoining a channel after being
+// kicked, which is doubled for every kick in a row to avoid flooding the
+// channel with joins.
+func (s *session) rejoinDelay(channel string) time.Duration {
+	k, ok := s.kicks[channel]
+	if !ok || time.Since(k.last) > k.delay+maxBackoff {
+		k = &kick{delay: rejoinDelay}
+		s.kicks[channel] = k
+	} else {
+		k.delay = min(k.delay*2, maxBackoff)
+	}
+	k.last = time.Now()
+
+	return k.delay
+}
+
+func (s *session) send(line string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+
+	_, err := s.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+func parse(line string) (string, string, []string) {
+	var prefix string
+	if strings.HasPrefix(line, ":") {
+		prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+
+	var trailing string
+	var hasTrailing bool
+	if i := strings.Index(line, " :"); i != -1 {
+		trailing = line[i+2:]
+		line = line[:i]
+		hasTrailing = true
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return prefix, "", nil
+	}
+
+	params := fields[1:]
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+
+	return prefix, strings.ToUpper(fields[0]), params
+}
+
+func last(params []string) string {
+	if len(params) == 0 {
+		return ""
+	}
+
+	return params[len(params)-1]
+}
+
+func format(data *writer.Data) string {
+	bc := data.Broadcast
+
+	var b strings.Builder
+	b.WriteString(colorize(bc.RawName, bc.Name))
+	b.WriteString(": ")
+	b.WriteString(colorize(bc.RawMessage, bc.Message))
+	fmt.Fprintf(&b, " [%s/%s] %s %s", data.Players(), data.MaxPlayers(), data.Server.Map, bc.Address)
+
+	return textfmt.TruncateBytes(b.String(), maxLineLength)
+}
+
+func colorize(raw, fallback string) string {
+	if raw == "" {
+		raw = fallback
+	}
+
+	var b strings.Builder
+	for _, s := range charset.Segments(raw) {
+		text := strings.NewReplacer("\r", "", "\n", " ").Replace(s.Text)
+
+		switch s.Color {
+		case charset.Brown:
+			b.WriteString(colorCode + colorBrown + text + resetCode)
+		case charset.Gold:
+			b.WriteString(colorCode + colorGold + text + resetCode)
+		default:
+			b.WriteString(text)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,212 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osm/qwbs/internal/qw/broadcast"
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+const testTimeout = time.Second * 2
+
+// server is the server end of a pipe that the client is connected to.
+type server struct {
+	conn  net.Conn
+	lines chan string
+}
+
+// start runs the client against a piped server until the test ends or the
+// returned function is called.
+func start(t *testing.T, opts Options) (*IRC, *server, func()) {
+	t.Helper()
+
+	client, conn := net.Pipe()
+	srv := &server{conn: conn, lines: make(chan string, 100)}
+
+	go func() {
+		defer close(srv.lines)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			srv.lines <- strings.TrimSuffix(scanner.Text(), "\r")
+		}
+	}()
+
+	i := New(opts)
+	i.dial = func(context.Context) (net.Conn, error) {
+		return client, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		i.Run(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		close(done)
+	}()
+
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(func() {
+		cancel()
+		conn.Close()
+		<-done
+	})
+
+	return i, srv, stop
+}
+
+func (s *server) send(t *testing.T, line string) {
+	t.Helper()
+
+	s.conn.SetWriteDeadline(time.Now().Add(testTimeout))
+	if _, err := io.WriteString(s.conn, line+"\r\n"); err != nil {
+		t.Fatalf("failed to send %q: %v", line, err)
+	}
+}
+
+func (s *server) expect(t *testing.T, want ...string) {
+	t.Helper()
+
+	for _, w := range want {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				t.Fatalf("connection closed, want %q", w)
+			}
+			if line != w {
+				t.Fatalf("got %q, want %q", line, w)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("timed out waiting for %q", w)
+		}
+	}
+}
+
+func register(t *testing.T, srv *server) {
+	t.Helper()
+
+	srv.expect(t, "NICK qwbs", "USER qwbs 0 * :qwbs")
+	srv.send(t, ":irc.example.com 001 qwbs :Welcome")
+	srv.expect(t, "JOIN #qw")
+}
+
+func TestRegister(t *testing.T) {
+	i, srv, _ := start(t, Options{Nick: "qwbs", Password: "secret", Channels: []string{"#qw", "#qw.pickup"}})
+
+	srv.expect(t, "PASS secret", "NICK qwbs", "USER qwbs 0 * :qwbs")
+
+	srv.send(t, ":irc.example.com 433 * qwbs :Nickname is already in use")
+	srv.expect(t, "NICK qwbs_")
+
+	srv.send(t, ":irc.example.com 001 qwbs_ :Welcome")
+	srv.expect(t, "JOIN #qw", "JOIN #qw.pickup")
+
+	// A repeated welcome, e.g. from a bouncer, joins the channels again.
+	srv.send(t, ":irc.example.com 001 qwbs_ :Welcome")
+	srv.expect(t, "JOIN #qw", "JOIN #qw.pickup")
+
+	if err := i.Check(); err != nil {
+		t.Errorf("got error %v after registering", err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	i, srv, _ := start(t, Options{Nick: "qwbs", Channels: []string{"#qw"}})
+
+	delivered := make(chan error, 1)
+	i.SetDelivery(func(_ time.Time, err error) {
+		delivered <- err
+	})
+
+	register(t, srv)
+
+	data := &writer.Data{
+		Broadcast: &broadcast.Broadcast{
+			Address:    "qw.example.com:28501",
+			MaxPlayers: "8",
+			Message:    "need 2 more",
+			Name:       "Bob",
+			Players:    "6",
+		},
+		Server: &serverstatus.Server{Map: "dm3"},
+	}
+	if err := i.Write(context.Background(), nil, data); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	srv.expect(t, "PRIVMSG #qw :Bob: need 2 more [6/8] dm3 qw.example.com:28501")
+	if err := <-delivered; err != nil {
+		t.Errorf("got delivery error %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	_, srv, _ := start(t, Options{Nick: "qwbs", Channels: []string{"#qw"}})
+
+	srv.expect(t, "NICK qwbs", "USER qwbs 0 * :qwbs")
+	srv.send(t, "PING :irc.example.com")
+	srv.expect(t, "PONG :irc.example.com")
+}
+
+func TestRejoin(t *testing.T) {
+	defer func(d time.Duration) { rejoinDelay = d }(rejoinDelay)
+	rejoinDelay = time.Millisecond * 10
+
+	_, srv, _ := start(t, Options{Nick: "qwbs", Channels: []string{"#qw"}})
+	register(t, srv)
+
+	// Kicks of other users are ignored.
+	srv.send(t, ":op!op@example.com KICK #qw other :bye")
+	srv.send(t, ":op!op@example.com KICK #qw qwbs :flood")
+	srv.expect(t, "JOIN #qw")
+}
+
+func TestRejoinDelay(t *testing.T) {
+	s := &session{kicks: make(map[string]*kick)}
+
+	want := []time.Duration{rejoinDelay, rejoinDelay * 2, rejoinDelay * 4}
+	for _, w := range want {
+		if got := s.rejoinDelay("#qw"); got != w {
+			t.Errorf("got delay %v, want %v", got, w)
+		}
+	}
+
+	if got := s.rejoinDelay("#qw.pickup"); got != rejoinDelay {
+		t.Errorf("got delay %v for another channel, want %v", got, rejoinDelay)
+	}
+
+	// The delay is reset once the channel hasn't been kicked from for a
+	// while.
+	s.kicks["#qw"].last = time.Now().Add(-s.kicks["#qw"].delay - maxBackoff - time.Second)
+	if got := s.rejoinDelay("#qw"); got != rejoinDelay {
+		t.Errorf("got delay %v after a quiet period, want %v", got, rejoinDelay)
+	}
+
+	for range 10 {
+		s.rejoinDelay("#qw")
+	}
+	if got := s.rejoinDelay("#qw"); got != maxBackoff {
+		t.Errorf("got delay %v, want it capped at %v", got, maxBackoff)
+	}
+}
+
+func TestQuit(t *testing.T) {
+	i, srv, stop := start(t, Options{Nick: "qwbs", Channels: []string{"#qw"}})
+	register(t, srv)
+
+	stop()
+	srv.expect(t, quitMessage)
+
+	if err := i.Check(); err == nil {
+		t.Error("got no error after disconnecting")
+	}
+}
@@ -0,0 +1,48 @@
+package irc
+
+import (
+	"context"
+	"time"
+)
+
+type limiter struct {
+	rate   time.Duration
+	burst  int
+	tokens int
+	last   time.Time
+}
+
+func newLimiter(rate time.Duration, burst int) *limiter {
+	return &limiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+func (l *limiter) wait(ctx context.Context) error {
+	l.refill()
+
+	if l.tokens == 0 {
+		select {
+		case <-time.After(l.rate - time.Since(l.last)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		l.refill()
+	}
+
+	l.tokens--
+	return nil
+}
+
+func (l *limiter) refill() {
+	n := int(time.Since(l.last) / l.rate)
+	if n == 0 {
+		return
+	}
+
+	l.tokens = min(l.tokens+n, l.burst)
+	l.last = l.last.Add(time.Duration(n) * l.rate)
+}
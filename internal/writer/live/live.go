@@ -0,0 +1,203 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/poster"
+)
+
+const (
+	DefaultBuffer = 16
+)
+
+const (
+	keepAliveInterval = time.Second * 30
+	writeTimeout      = time.Second * 10
+	shutdownTimeout   = time.Second * 5
+)
+
+type Options struct {
+	Address string
+	Buffer  int
+	Replay  int
+	Origins []string
+}
+
+type Live struct {
+	opts Options
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	history [][]byte
+}
+
+type client struct {
+	ch     chan []byte
+	done   chan struct{}
+	once   sync.Once
+	remote string
+}
+
+func (c *client) close() {
+	c.once.Do(func() {
+		close(c.done)
+	})
+}
+
+func New(opts Options) *Live {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultBuffer
+	}
+
+	return &Live{
+		opts:    opts,
+		clients: make(map[*client]struct{}),
+	}
+}
+
+func (l *Live) Write(_ context.Context, logger *slog.Logger, data *writer.Data) error {
+	payload, err := poster.EncodeJSON(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.opts.Replay > 0 {
+		l.history = append(l.history, payload)
+		if len(l.history) > l.opts.Replay {
+			l.history = l.history[len(l.history)-l.opts.Replay:]
+		}
+	}
+
+	for c := range l.clients {
+		select {
+		case c.ch <- payload:
+		default:
+			logger.Info("Disconnecting slow live feed client", "client", c.remote)
+			delete(l.clients, c)
+			c.close()
+		}
+	}
+
+	return nil
+}
+
+// Close disconnects the clients of the feed.
+func (l *Live) Close() error {
+	l.disconnect()
+	return nil
+}
+
+// Address returns the address the feed is served on, which is empty when the
+// feed is served by the HTTP server of the service.
+func (l *Live) Address() string {
+	return l.opts.Address
+}
+
+func (l *Live) Handler(logger *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		l.serveEvents(logger, w, r)
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		l.serveWebSocket(logger, w, r)
+	})
+	return mux
+}
+
+// Run serves the feed on its own address, if it has one, until the context
+// is cancelled and then disconnects the clients, which are otherwise kept by
+// a feed that is served by the HTTP server of the service.
+func (l *Live) Run(ctx context.Context, logger *slog.Logger) {
+	defer l.disconnect()
+
+	if l.opts.Address == "" {
+		<-ctx.Done()
+		return
+	}
+
+	srv := &http.Server{
+		Addr:              l.opts.Address,
+		Handler:           l.Handler(logger),
+		ReadHeaderTimeout: writeTimeout,
+		BaseContext: func(_ net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Serving live feed", "address", l.opts.Address)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("Live feed server failed", "address", l.opts.Address, "error", err)
+	}
+}
+
+// allowed reports whether the origin of a request may use the feed. Requests
+// without an Origin header aren't made by browsers and are allowed, other
+// requests must come from the same host or from one of the listed origins.
+func (l *Live) allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	for _, o := range l.opts.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func (l *Live) disconnect() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for c := range l.clients {
+		delete(l.clients, c)
+		c.close()
+	}
+}
+
+func (l *Live) subscribe(remote string) (*client, [][]byte) {
+	c := &client{
+		ch:     make(chan []byte, l.opts.Buffer),
+		done:   make(chan struct{}),
+		remote: remote,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.clients[c] = struct{}{}
+	return c, append([][]byte(nil), l.history...)
+}
+
+func (l *Live) unsubscribe(c *client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.clients, c)
+	c.close()
+}
@@ -0,0 +1,363 @@
+package live
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osm/qwbs/internal/qw/broadcast"
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+const testTimeout = time.Second * 2
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func newServer(t *testing.T, l *Live) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(l.Handler(discard))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func testData() *writer.Data {
+	return &writer.Data{
+		Broadcast: &broadcast.Broadcast{Address: "qw.example.com:28501", Message: "need 2 more"},
+		Server:    &serverstatus.Server{Map: "dm3"},
+	}
+}
+
+// upgrade opens a WebSocket to the server with the key from RFC 6455 and
+// returns the connection and the handshake response.
+func upgrade(t *testing.T, srv *httptest.Server, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(testTimeout))
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/ws", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, r, resp
+}
+
+func connect(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, r, resp := upgrade(t, srv, "")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %s, want 101", resp.Status)
+	}
+
+	return conn, r
+}
+
+// sendFrame sends a masked client frame.
+func sendFrame(t *testing.T, conn net.Conn, op byte, payload []byte) {
+	t.Helper()
+
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFrame reads an unmasked server frame and returns its header, opcode
+// and payload.
+func readFrame(t *testing.T, r io.Reader) ([]byte, byte, []byte) {
+	t.Helper()
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+
+	if header[0]&0x80 == 0 {
+		t.Error("got a fragmented frame")
+	}
+	if header[1]&0x80 != 0 {
+		t.Error("got a masked server frame")
+	}
+
+	n := uint64(header[1])
+	switch n {
+	case 126:
+		ext := make([]byte, 2)
+		io.ReadFull(r, ext)
+		header = append(header, ext...)
+		n = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		io.ReadFull(r, ext)
+		header = append(header, ext...)
+		n = binary.BigEndian.Uint64(ext)
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("failed to read payload: %v", err)
+	}
+
+	return header, header[0] & 0x0f, payload
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	srv := newServer(t, New(Options{}))
+
+	_, _, resp := upgrade(t, srv, "")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %s, want 101", resp.Status)
+	}
+
+	// The accept value for the key in RFC 6455 section 1.3.
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got Sec-WebSocket-Accept %q", got)
+	}
+	if got := resp.Header.Get("Upgrade"); !strings.EqualFold(got, "websocket") {
+		t.Errorf("got Upgrade %q, want websocket", got)
+	}
+}
+
+func TestWebSocketUpgradeRequired(t *testing.T) {
+	srv := newServer(t, New(Options{}))
+
+	resp, err := http.Get(srv.URL + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("got status %s, want 426", resp.Status)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	l := New(Options{Origins: []string{"https://qw.example.com"}})
+	srv := newServer(t, l)
+
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"https://qw.example.com", http.StatusSwitchingProtocols},
+		{"http://" + srv.Listener.Addr().String(), http.StatusSwitchingProtocols},
+		{"https://evil.example.com", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		_, _, resp := upgrade(t, srv, tt.origin)
+		if resp.StatusCode != tt.want {
+			t.Errorf("got status %s for origin %q, want %d", resp.Status, tt.origin, tt.want)
+		}
+	}
+}
+
+func TestWebSocketBroadcast(t *testing.T) {
+	l := New(Options{Replay: 1})
+	srv := newServer(t, l)
+
+	if err := l.Write(context.Background(), discard, testData()); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	// The broadcast written before connecting is replayed.
+	_, r := connect(t, srv)
+	_, op, payload := readFrame(t, r)
+	if op != opText {
+		t.Fatalf("got opcode %d, want text", op)
+	}
+	if !bytes.Contains(payload, []byte(`"need 2 more"`)) {
+		t.Errorf("got payload %s, want the broadcast", payload)
+	}
+}
+
+func TestWebSocketPing(t *testing.T) {
+	srv := newServer(t, New(Options{}))
+	conn, r := connect(t, srv)
+
+	sendFrame(t, conn, opPing, []byte("hello"))
+	_, op, payload := readFrame(t, r)
+	if op != opPong || string(payload) != "hello" {
+		t.Errorf("got opcode %d with %q, want a pong with the ping payload", op, payload)
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	srv := newServer(t, New(Options{}))
+	conn, r := connect(t, srv)
+
+	code := binary.BigEndian.AppendUint16(nil, 1000)
+	sendFrame(t, conn, opClose, code)
+
+	_, op, payload := readFrame(t, r)
+	if op != opClose || !bytes.Equal(payload, code) {
+		t.Errorf("got opcode %d with %v, want the close to be echoed", op, payload)
+	}
+
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("got error %v after closing, want the connection to be closed", err)
+	}
+}
+
+func TestWebSocketUnmasked(t *testing.T) {
+	srv := newServer(t, New(Options{}))
+	conn, r := connect(t, srv)
+
+	// Clients must mask their frames.
+	conn.Write([]byte{0x80 | opPing, 0})
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("got error %v after an unmasked frame, want the connection to be closed", err)
+	}
+}
+
+func TestWebSocketFrameLength(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{125, []byte{0x81, 125}},
+		{126, []byte{0x81, 126, 0, 126}},
+		{65535, []byte{0x81, 126, 0xff, 0xff}},
+		{65536, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		ws := &wsConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}
+
+		payload := bytes.Repeat([]byte("x"), tt.n)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- ws.write(opText, payload)
+		}()
+
+		client.SetDeadline(time.Now().Add(testTimeout))
+		header, _, got := readFrame(t, client)
+		if !bytes.Equal(header, tt.want) {
+			t.Errorf("got header %v for %d bytes, want %v", header, tt.n, tt.want)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("got %d bytes of payload, want %d", len(got), tt.n)
+		}
+		if err := <-errc; err != nil {
+			t.Errorf("got error %v", err)
+		}
+
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestEventsOrigin(t *testing.T) {
+	l := New(Options{Origins: []string{"https://qw.example.com"}, Replay: 1})
+	srv := newServer(t, l)
+
+	if err := l.Write(context.Background(), discard, testData()); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %s for another origin, want 403", resp.Status)
+	}
+
+	req.Header.Set("Origin", "https://qw.example.com")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://qw.example.com" {
+		t.Errorf("got Access-Control-Allow-Origin %q, want the origin", got)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	if line, _ := r.ReadString('\n'); line != "event: broadcast\n" {
+		t.Errorf("got %q, want a broadcast event", line)
+	}
+	if line, _ := r.ReadString('\n'); !strings.Contains(line, `"need 2 more"`) {
+		t.Errorf("got %q, want the broadcast", line)
+	}
+}
+
+func TestRunDisconnect(t *testing.T) {
+	l := New(Options{})
+	srv := newServer(t, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.Run(ctx, discard)
+		close(done)
+	}()
+
+	_, r := connect(t, srv)
+
+	// Clients of a feed served by another server are disconnected when the
+	// writer is stopped, e.g. when it's removed on reload.
+	deadline := time.Now().Add(testTimeout)
+	for {
+		l.mu.Lock()
+		n := len(l.clients)
+		l.mu.Unlock()
+		if n == 1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	_, op, payload := readFrame(t, r)
+	if op != opClose || binary.BigEndian.Uint16(payload) != 1008 {
+		t.Errorf("got opcode %d with %v, want a close", op, payload)
+	}
+}
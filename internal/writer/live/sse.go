@@ -0,0 +1,85 @@
+package live
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+func (l *Live) serveEvents(logger *slog.Logger, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !l.allowed(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	// Browsers only let pages on other origins read the stream if it's
+	// allowed by CORS.
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	c, replay := l.subscribe(r.RemoteAddr)
+	defer l.unsubscribe(c)
+
+	logger.Debug("Live feed client connected", "client", c.remote, "protocol", "sse")
+
+	send := func(payload []byte) error {
+		if err := rc.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "event: broadcast\ndata: %s\n\n", payload); err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	for _, payload := range replay {
+		if err := send(payload); err != nil {
+			return
+		}
+	}
+
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case payload := <-c.ch:
+			if err := send(payload); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
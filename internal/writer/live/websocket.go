@@ -0,0 +1,223 @@
+package live
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsMaxFrameSize = 1024 * 64
+
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xa
+)
+
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	mu   sync.Mutex
+}
+
+func (l *Live) serveWebSocket(logger *slog.Logger, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+
+	// Browsers don't apply CORS to WebSockets, so the origin is checked
+	// to keep other websites from opening the feed.
+	if !l.allowed(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	ws := &wsConn{conn: conn, rw: rw}
+
+	c, replay := l.subscribe(r.RemoteAddr)
+	defer l.unsubscribe(c)
+
+	logger.Debug("Live feed client connected", "client", c.remote, "protocol", "websocket")
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- ws.read()
+	}()
+
+	for _, payload := range replay {
+		if err := ws.write(opText, payload); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case payload := <-c.ch:
+			if err := ws.write(opText, payload); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := ws.write(opPing, nil); err != nil {
+				return
+			}
+		case <-readErr:
+			return
+		case <-c.done:
+			ws.write(opClose, binary.BigEndian.AppendUint16(nil, 1008))
+			return
+		case <-r.Context().Done():
+			ws.write(opClose, binary.BigEndian.AppendUint16(nil, 1001))
+			return
+		}
+	}
+}
+
+func (ws *wsConn) write(op byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if err := ws.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+
+	header := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	if _, err := ws.rw.Write(header); err != nil {
+		return err
+	}
+
+	if _, err := ws.rw.Write(payload); err != nil {
+		return err
+	}
+
+	return ws.rw.Flush()
+}
+
+// read consumes frames sent by the client, answering pings and returning
+// when the client closes the connection.
+func (ws *wsConn) read() error {
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(ws.rw, head[:]); err != nil {
+			return err
+		}
+
+		op := head[0] & 0x0f
+		masked := head[1]&0x80 != 0
+		n := uint64(head[1] & 0x7f)
+
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+				return err
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(ws.rw, ext[:]); err != nil {
+				return err
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+
+		if !masked {
+			return errors.New("unmasked client frame")
+		}
+
+		if n > wsMaxFrameSize {
+			return errors.New("client frame too large")
+		}
+
+		var mask [4]byte
+		if _, err := io.ReadFull(ws.rw, mask[:]); err != nil {
+			return err
+		}
+
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(ws.rw, payload); err != nil {
+			return err
+		}
+
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch op {
+		case opClose:
+			ws.write(opClose, payload)
+			return io.EOF
+		case opPing:
+			if err := ws.write(opPong, payload); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func headerContains(h http.Header, name, value string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,168 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/poster"
+	"github.com/osm/qwbs/internal/writer/textfmt"
+	"github.com/osm/qwbs/internal/writer/tmpl"
+)
+
+const (
+	eventType  = "m.room.message"
+	htmlFormat = "org.matrix.custom.html"
+	msgType    = "m.notice"
+)
+
+type Message struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+type ErrorResponse struct {
+	ErrCode string `json:"errcode"`
+	Error   string `json:"error"`
+}
+
+type Matrix struct {
+	homeserver string
+	token      string
+	rooms      []string
+	template   *template.Template
+	client     *poster.Client
+	txnPrefix  string
+	txnCounter atomic.Uint64
+}
+
+func New(homeserver, token string, rooms []string, t *template.Template) *Matrix {
+	return &Matrix{
+		homeserver: strings.TrimSuffix(homeserver, "/"),
+		token:      token,
+		rooms:      rooms,
+		template:   t,
+		client:     poster.NewClient(),
+		txnPrefix:  fmt.Sprintf("qwbs-%d", time.Now().UnixNano()),
+	}
+}
+
+func (m *Matrix) Write(ctx context.Context, _ *slog.Logger, data *writer.Data) error {
+	msg, err := m.message(data)
+	if err != nil {
+		return fmt.Errorf("failed to format data: %w", err)
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode Matrix message: %w", err)
+	}
+
+	var errs []error
+	for _, room := range m.rooms {
+		if err := m.send(ctx, room, body); err != nil {
+			errs = append(errs, fmt.Errorf("failed to send Matrix message to %s: %w", room, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m *Matrix) send(ctx context.Context, room string, body []byte) error {
+	// The transaction id is created once per event so that retries of the
+	// same request are deduplicated by the homeserver.
+	txnID := fmt.Sprintf("%s-%d", m.txnPrefix, m.txnCounter.Add(1))
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/%s/%s",
+		m.homeserver, url.PathEscape(room), eventType, url.PathEscape(txnID))
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+m.token)
+	header.Set("Content-Type", "application/json")
+
+	respBody, err := m.client.Do(ctx, http.MethodPut, endpoint, header, body)
+
+	return m.error(respBody, err)
+}
+
+func (m *Matrix) Close() error {
+	m.client.CloseIdleConnections()
+	return nil
+}
+
+// Probe checks that the access token is valid.
+func (m *Matrix) Probe(ctx context.Context) error {
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+m.token)
+
+	endpoint := m.homeserver + "/_matrix/client/v3/account/whoami"
+	respBody, err := m.client.Do(ctx, http.MethodGet, endpoint, header, nil)
+
+	return m.error(respBody, err)
+}
+
+func (m *Matrix) error(respBody []byte, err error) error {
+	var statusErr *poster.StatusError
+	if errors.As(err, &statusErr) {
+		var e ErrorResponse
+		if json.Unmarshal(respBody, &e) == nil && e.ErrCode != "" {
+			return fmt.Errorf("%w: %s: %s", err, e.ErrCode, e.Error)
+		}
+	}
+
+	return err
+}
+
+func (m *Matrix) message(data *writer.Data) (*Message, error) {
+	if m.template != nil {
+		text, err := tmpl.Execute(m.template, data)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Message{
+			MsgType:       msgType,
+			Body:          text,
+			Format:        htmlFormat,
+			FormattedBody: strings.ReplaceAll(html.EscapeString(text), "\n", "<br>"),
+		}, nil
+	}
+
+	bc := data.Broadcast
+	sv := data.Server
+
+	players := textfmt.Join(sv.Playing(), ", ")
+
+	body := fmt.Sprintf("%s: %s\n%s [%s/%s] %s",
+		bc.Name, bc.Message, bc.Address, data.Players(), data.MaxPlayers(), sv.Map)
+	formatted := fmt.Sprintf("<strong>%s</strong>: %s<br><code>%s</code> [%s/%s] %s",
+		html.EscapeString(bc.Name),
+		html.EscapeString(bc.Message),
+		html.EscapeString(bc.Address),
+		html.EscapeString(data.Players()),
+		html.EscapeString(data.MaxPlayers()),
+		html.EscapeString(sv.Map))
+
+	if players != "" {
+		body += "\n" + players
+		formatted += "<br><em>" + html.EscapeString(players) + "</em>"
+	}
+
+	return &Message{
+		MsgType:       msgType,
+		Body:          body,
+		Format:        htmlFormat,
+		FormattedBody: formatted,
+	}, nil
+}
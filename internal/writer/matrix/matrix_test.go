@@ -0,0 +1,181 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/osm/qwbs/internal/qw/broadcast"
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+type request struct {
+	path    string
+	auth    string
+	message Message
+}
+
+// homeserver is a local stand-in for the client-server API that records the
+// send requests and replies with the queued responses, or 200 once they're
+// used up.
+type homeserver struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requests  []request
+	responses []func(w http.ResponseWriter)
+}
+
+func newHomeserver(t *testing.T, responses ...func(w http.ResponseWriter)) *homeserver {
+	hs := &homeserver{responses: responses}
+	hs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("got method %s, want PUT", r.Method)
+		}
+
+		var msg Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("failed to decode message: %v", err)
+		}
+
+		hs.mu.Lock()
+		hs.requests = append(hs.requests, request{
+			path:    r.URL.EscapedPath(),
+			auth:    r.Header.Get("Authorization"),
+			message: msg,
+		})
+		var respond func(w http.ResponseWriter)
+		if len(hs.responses) > 0 {
+			respond, hs.responses = hs.responses[0], hs.responses[1:]
+		}
+		hs.mu.Unlock()
+
+		if respond != nil {
+			respond(w)
+			return
+		}
+		io.WriteString(w, `{"event_id":"$event"}`)
+	}))
+	t.Cleanup(hs.Close)
+
+	return hs
+}
+
+func testData() *writer.Data {
+	return &writer.Data{
+		Broadcast: &broadcast.Broadcast{
+			Address:    "qw.example.com:28501",
+			MaxPlayers: "8",
+			Message:    "need <2> more",
+			Name:       "Bob",
+			Players:    "2",
+		},
+		Server: &serverstatus.Server{
+			Map:     "dm3",
+			Players: []serverstatus.Player{{Name: "Bob"}, {Name: "Alice"}},
+		},
+	}
+}
+
+func write(m *Matrix) error {
+	return m.Write(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), testData())
+}
+
+// txnID returns the transaction id of a send request path.
+func txnID(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+func TestWrite(t *testing.T) {
+	hs := newHomeserver(t)
+	m := New(hs.URL+"/", "secret", []string{"!abc:example.com", "#qw/pickup:example.com"}, nil)
+
+	if err := write(m); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if len(hs.requests) != 2 {
+		t.Fatalf("got %d requests, want one per room", len(hs.requests))
+	}
+
+	prefixes := []string{
+		"/_matrix/client/v3/rooms/%21abc:example.com/send/m.room.message/",
+		"/_matrix/client/v3/rooms/%23qw%2Fpickup:example.com/send/m.room.message/",
+	}
+	for i, req := range hs.requests {
+		if !strings.HasPrefix(req.path, prefixes[i]) {
+			t.Errorf("got path %q, want prefix %q", req.path, prefixes[i])
+		}
+		if req.auth != "Bearer secret" {
+			t.Errorf("got authorization %q", req.auth)
+		}
+	}
+
+	if txnID(hs.requests[0].path) == txnID(hs.requests[1].path) {
+		t.Errorf("got the same transaction id %q for both rooms", txnID(hs.requests[0].path))
+	}
+
+	msg := hs.requests[0].message
+	want := Message{
+		MsgType:       msgType,
+		Body:          "Bob: need <2> more\nqw.example.com:28501 [2/8] dm3\nBob, Alice",
+		Format:        htmlFormat,
+		FormattedBody: "<strong>Bob</strong>: need &lt;2&gt; more<br><code>qw.example.com:28501</code> [2/8] dm3<br><em>Bob, Alice</em>",
+	}
+	if msg != want {
+		t.Errorf("got message %+v, want %+v", msg, want)
+	}
+
+	// Transaction ids must not be reused by later events.
+	if err := write(m); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if txnID(hs.requests[2].path) == txnID(hs.requests[0].path) {
+		t.Errorf("got transaction id %q reused for a new event", txnID(hs.requests[0].path))
+	}
+}
+
+func TestWriteRetry(t *testing.T) {
+	hs := newHomeserver(t, func(w http.ResponseWriter) {
+		w.Header().Set("Retry-After", "0.01")
+		w.WriteHeader(http.StatusTooManyRequests)
+		io.WriteString(w, `{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests"}`)
+	})
+	m := New(hs.URL, "secret", []string{"!abc:example.com"}, nil)
+
+	if err := write(m); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if len(hs.requests) != 2 {
+		t.Fatalf("got %d requests, want the event to be retried once", len(hs.requests))
+	}
+
+	if hs.requests[0].path != hs.requests[1].path {
+		t.Errorf("got retry %q of %q, want the same transaction id", hs.requests[1].path, hs.requests[0].path)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	hs := newHomeserver(t, func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"errcode":"M_FORBIDDEN","error":"User is not in the room"}`)
+	})
+	m := New(hs.URL, "secret", []string{"!abc:example.com", "!def:example.com"}, nil)
+
+	err := write(m)
+	if err == nil || !strings.Contains(err.Error(), "!abc:example.com") ||
+		!strings.Contains(err.Error(), "M_FORBIDDEN: User is not in the room") {
+		t.Errorf("got error %v, want the room and the Matrix error", err)
+	}
+
+	if len(hs.requests) != 2 {
+		t.Errorf("got %d requests, want the rejected event not to be retried", len(hs.requests))
+	}
+}
@@ -0,0 +1,375 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/poster"
+	"github.com/osm/qwbs/internal/writer/tmpl"
+)
+
+const (
+	DefaultKeepAlive = time.Second * 60
+	DefaultTopic     = "qwbs/broadcast/{{.Broadcast.Address}}"
+)
+
+const (
+	dialTimeout  = time.Second * 30
+	ackTimeout   = time.Second * 30
+	writeTimeout = time.Second * 30
+	maxBackoff   = time.Minute * 5
+	queueSize    = 100
+)
+
+var (
+	topicSanitizer = strings.NewReplacer("+", "_", "#", "_", "\x00", "")
+
+	// minBackoff is the first wait before reconnecting, a variable so that
+	// tests can shorten it.
+	minBackoff = time.Second
+)
+
+type Options struct {
+	Address   string
+	TLS       bool
+	ClientID  string
+	Username  string
+	Password  string
+	Topic     *template.Template
+	QoS       byte
+	Retain    bool
+	KeepAlive time.Duration
+}
+
+type MQTT struct {
+	opts      Options
+	dial      func(ctx context.Context) (net.Conn, error)
+	queue     chan *message
+	pending   *message
+	nextID    uint16
+	delivery  writer.Delivery
+	connected atomic.Bool
+}
+
+// message is a message to publish. For QoS 2, received is set once the
+// broker has acknowledged the message with PUBREC, after which only the
+// release remains, also after reconnecting.
+type message struct {
+	topic    string
+	payload  []byte
+	id       uint16
+	sent     bool
+	received bool
+	queued   time.Time
+}
+
+type session struct {
+	conn    net.Conn
+	mu      sync.Mutex
+	packets chan *packet
+	readErr chan error
+}
+
+func New(opts Options) (*MQTT, error) {
+	if opts.Topic == nil {
+		t, err := tmpl.Parse("topic", DefaultTopic)
+		if err != nil {
+			return nil, err
+		}
+		opts.Topic = t
+	}
+
+	if opts.QoS > 2 {
+		return nil, fmt.Errorf("invalid qos %d", opts.QoS)
+	}
+
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = DefaultKeepAlive
+	}
+
+	if opts.ClientID == "" {
+		b := make([]byte, 4)
+		rand.Read(b)
+		opts.ClientID = "qwbs-" + hex.EncodeToString(b)
+	}
+
+	m := &MQTT{
+		opts:     opts,
+		queue:    make(chan *message, queueSize),
+		delivery: func(time.Time, error) {},
+	}
+	m.dial = m.dialBroker
+
+	return m, nil
+}
+
+func (m *MQTT) Write(_ context.Context, _ *slog.Logger, data *writer.Data) error {
+	topic, err := tmpl.Execute(m.opts.Topic, data)
+	if err != nil {
+		return fmt.Errorf("failed to render MQTT topic: %w", err)
+	}
+
+	payload, err := poster.EncodeJSON(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %w", err)
+	}
+
+	msg := &message{topic: topicSanitizer.Replace(topic), payload: payload, queued: time.Now()}
+	select {
+	case m.queue <- msg:
+		return nil
+	default:
+		return fmt.Errorf("MQTT queue is full, dropping message to %s", topic)
+	}
+}
+
+// SetDelivery sets the function that is called when a queued message has
+// been published. Messages that fail to be published are retried after
+// reconnecting.
+func (m *MQTT) SetDelivery(delivery writer.Delivery) {
+	m.delivery = delivery
+}
+
+// Close does nothing, the connection is only made and closed by Run.
+func (m *MQTT) Close() error {
+	return nil
+}
+
+func (m *MQTT) Check() error {
+	if !m.connected.Load() {
+		return errors.New("not connected to MQTT broker")
+	}
+
+	return nil
+}
+
+func (m *MQTT) Run(ctx context.Context, logger *slog.Logger) {
+	backoff := minBackoff
+
+	for {
+		start := time.Now()
+		err := m.connect(ctx, logger)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+
+		logger.Error("MQTT connection lost, reconnecting",
+			"broker", m.opts.Address, "error", err, "backoff", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (m *MQTT) dialBroker(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if !m.opts.TLS {
+		return dialer.DialContext(ctx, "tcp", m.opts.Address)
+	}
+
+	host, _, _ := net.SplitHostPort(m.opts.Address)
+	return (&tls.Dialer{
+		NetDialer: dialer,
+		Config:    &tls.Config{ServerName: host},
+	}).DialContext(ctx, "tcp", m.opts.Address)
+}
+
+func (m *MQTT) connect(ctx context.Context, logger *slog.Logger) error {
+	conn, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s := &session{
+		conn:    conn,
+		packets: make(chan *packet),
+		readErr: make(chan error, 1),
+	}
+
+	keepAlive := uint16(m.opts.KeepAlive / time.Second)
+	if err := s.send(connectPacket(m.opts.ClientID, m.opts.Username, m.opts.Password, keepAlive)); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	if err := conn.SetReadDeadline(time.Now().Add(ackTimeout)); err != nil {
+		return err
+	}
+
+	p, err := readPacket(r)
+	if err != nil {
+		return fmt.Errorf("failed to read CONNACK: %w", err)
+	}
+
+	if p.typ != packetConnAck || len(p.body) != 2 {
+		return fmt.Errorf("unexpected packet 0x%02x, expected CONNACK", p.typ)
+	}
+
+	if rc := p.body[1]; rc != 0 {
+		if msg, ok := connectErrors[rc]; ok {
+			return fmt.Errorf("connection refused: %s", msg)
+		}
+		return fmt.Errorf("connection refused: return code %d", rc)
+	}
+
+	logger.Info("Connected to MQTT broker", "broker", m.opts.Address, "client_id", m.opts.ClientID)
+
+	m.connected.Store(true)
+	defer m.connected.Store(false)
+
+	go s.read(r, m.opts.KeepAlive)
+
+	ticker := time.NewTicker(m.opts.KeepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		if m.pending == nil {
+			select {
+			case msg := <-m.queue:
+				m.pending = msg
+			case <-ticker.C:
+				if err := s.send([]byte{packetPingReq, 0}); err != nil {
+					return err
+				}
+				continue
+			case err := <-s.readErr:
+				return err
+			case <-ctx.Done():
+				s.send([]byte{packetDisconnect, 0})
+				return ctx.Err()
+			}
+		}
+
+		if err := m.publish(ctx, logger, s, m.pending); err != nil {
+			return err
+		}
+		m.delivery(m.pending.queued, nil)
+		m.pending = nil
+	}
+}
+
+// publish publishes the message and waits for the acknowledgements of its
+// QoS. A message that was already sent is published again with the DUP flag,
+// unless the broker has received it, in which case it's only released.
+func (m *MQTT) publish(ctx context.Context, logger *slog.Logger, s *session, msg *message) error {
+	if m.opts.QoS > 0 && msg.id == 0 {
+		m.nextID++
+		if m.nextID == 0 {
+			m.nextID++
+		}
+		msg.id = m.nextID
+	}
+
+	if !msg.received {
+		pkt := publishPacket(msg.topic, msg.payload, m.opts.QoS, m.opts.Retain, msg.sent, msg.id)
+		if err := s.send(pkt); err != nil {
+			return err
+		}
+		msg.sent = true
+	}
+
+	switch m.opts.QoS {
+	case 1:
+		return s.await(ctx, logger, packetPubAck, msg.id)
+	case 2:
+		if !msg.received {
+			if err := s.await(ctx, logger, packetPubRec, msg.id); err != nil {
+				return err
+			}
+			msg.received = true
+		}
+		if err := s.send(ackPacket(packetPubRel, msg.id)); err != nil {
+			return err
+		}
+		return s.await(ctx, logger, packetPubComp, msg.id)
+	}
+
+	return nil
+}
+
+// await waits for the acknowledgement of the given type and packet id. Other
+// acknowledgements, e.g. for a message published before a reconnect, are
+// logged and skipped.
+func (s *session) await(ctx context.Context, logger *slog.Logger, typ byte, id uint16) error {
+	timeout := time.NewTimer(ackTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case p := <-s.packets:
+			if p.typ&0xf0 == typ&0xf0 && p.packetID() == id {
+				return nil
+			}
+			logger.Warn("Unexpected MQTT acknowledgement",
+				"type", fmt.Sprintf("0x%02x", p.typ), "id", p.packetID(),
+				"expected_type", fmt.Sprintf("0x%02x", typ), "expected_id", id)
+		case err := <-s.readErr:
+			return err
+		case <-timeout.C:
+			return fmt.Errorf("timed out waiting for acknowledgement of packet %d", id)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *session) read(r *bufio.Reader, keepAlive time.Duration) {
+	for {
+		// The broker disconnects clients after one and a half keep alive
+		// intervals without traffic, so anything longer means it's gone.
+		if err := s.conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2)); err != nil {
+			s.readErr <- err
+			return
+		}
+
+		p, err := readPacket(r)
+		if err != nil {
+			s.readErr <- err
+			return
+		}
+
+		switch p.typ & 0xf0 {
+		case packetPingResp:
+		case packetPubAck, packetPubRec, packetPubComp:
+			select {
+			case s.packets <- p:
+			case <-time.After(ackTimeout):
+			}
+		}
+	}
+}
+
+func (s *session) send(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+
+	_, err := s.conn.Write(b)
+	return err
+}
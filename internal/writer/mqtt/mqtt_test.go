@@ -0,0 +1,301 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/osm/qwbs/internal/qw/broadcast"
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+const testTimeout = time.Second * 2
+
+// broker is the broker end of a piped connection.
+type broker struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// logBuffer collects the log output of the client.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// start runs the client with every dial connected to a new broker, which is
+// sent on the returned channel.
+func start(t *testing.T, opts Options) (*MQTT, chan *broker, chan error, *logBuffer) {
+	t.Helper()
+
+	m, err := New(opts)
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	brokers := make(chan *broker, 10)
+	m.dial = func(context.Context) (net.Conn, error) {
+		client, conn := net.Pipe()
+		t.Cleanup(func() { conn.Close() })
+		brokers <- &broker{conn: conn, r: bufio.NewReader(conn)}
+		return client, nil
+	}
+
+	delivered := make(chan error, 10)
+	m.SetDelivery(func(_ time.Time, err error) {
+		delivered <- err
+	})
+
+	logs := &logBuffer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx, slog.New(slog.NewTextHandler(logs, nil)))
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return m, brokers, delivered, logs
+}
+
+// accept waits for the next connection and accepts it.
+func accept(t *testing.T, brokers chan *broker) *broker {
+	t.Helper()
+
+	var b *broker
+	select {
+	case b = <-brokers:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a connection")
+	}
+
+	if p := b.expect(t, packetConnect); p.body[7]&flagCleanSession == 0 {
+		t.Error("got a session that isn't clean")
+	}
+	b.send(t, []byte{packetConnAck, 2, 0, 0})
+
+	return b
+}
+
+func (b *broker) send(t *testing.T, pkt []byte) {
+	t.Helper()
+
+	b.conn.SetWriteDeadline(time.Now().Add(testTimeout))
+	if _, err := b.conn.Write(pkt); err != nil {
+		t.Fatalf("failed to send packet 0x%02x: %v", pkt[0], err)
+	}
+}
+
+// expect reads the next packet, skipping pings, and checks its type without
+// the flags of PUBLISH packets.
+func (b *broker) expect(t *testing.T, typ byte) *packet {
+	t.Helper()
+
+	for {
+		b.conn.SetReadDeadline(time.Now().Add(testTimeout))
+		p, err := readPacket(b.r)
+		if err != nil {
+			t.Fatalf("failed to read packet 0x%02x: %v", typ, err)
+		}
+		if p.typ == packetPingReq {
+			continue
+		}
+
+		got := p.typ
+		if got&0xf0 == packetPublish {
+			got = packetPublish
+		}
+		if got != typ {
+			t.Fatalf("got packet 0x%02x, want 0x%02x", p.typ, typ)
+		}
+
+		return p
+	}
+}
+
+// expectPublish reads a PUBLISH packet and returns its flags and packet id.
+func (b *broker) expectPublish(t *testing.T, topic string) (byte, uint16) {
+	t.Helper()
+
+	p := b.expect(t, packetPublish)
+	n := int(binary.BigEndian.Uint16(p.body))
+	if got := string(p.body[2 : 2+n]); got != topic {
+		t.Errorf("got topic %q, want %q", got, topic)
+	}
+
+	var id uint16
+	if qos := p.typ >> 1 & 0x03; qos > 0 {
+		id = binary.BigEndian.Uint16(p.body[2+n:])
+	}
+
+	return p.typ & 0x0f, id
+}
+
+func expectDelivered(t *testing.T, delivered chan error) {
+	t.Helper()
+
+	select {
+	case err := <-delivered:
+		if err != nil {
+			t.Errorf("got delivery error %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for delivery")
+	}
+}
+
+func write(t *testing.T, m *MQTT) {
+	t.Helper()
+
+	data := &writer.Data{
+		Broadcast: &broadcast.Broadcast{Address: "qw.example.com:28501", Message: "need 2 more"},
+		Server:    &serverstatus.Server{Map: "dm3"},
+	}
+	if err := m.Write(context.Background(), nil, data); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+const topic = "qwbs/broadcast/qw.example.com:28501"
+
+func TestWriteQoS0(t *testing.T) {
+	m, brokers, delivered, _ := start(t, Options{Address: "broker:1883"})
+	b := accept(t, brokers)
+
+	write(t, m)
+	if flags, _ := b.expectPublish(t, topic); flags != 0 {
+		t.Errorf("got flags 0x%x, want none", flags)
+	}
+	expectDelivered(t, delivered)
+}
+
+func TestWriteQoS1(t *testing.T) {
+	m, brokers, delivered, logs := start(t, Options{Address: "broker:1883", QoS: 1})
+	b := accept(t, brokers)
+
+	write(t, m)
+	_, id := b.expectPublish(t, topic)
+
+	// An acknowledgement of another packet is skipped.
+	b.send(t, ackPacket(packetPubAck, id+1))
+	b.send(t, ackPacket(packetPubAck, id))
+	expectDelivered(t, delivered)
+
+	if !strings.Contains(logs.String(), "Unexpected MQTT acknowledgement") {
+		t.Errorf("got no log of the unexpected acknowledgement in %q", logs)
+	}
+}
+
+func TestWriteQoS2(t *testing.T) {
+	m, brokers, delivered, _ := start(t, Options{Address: "broker:1883", QoS: 2})
+	b := accept(t, brokers)
+
+	write(t, m)
+	_, id := b.expectPublish(t, topic)
+	b.send(t, ackPacket(packetPubRec, id))
+
+	if got := b.expect(t, packetPubRel).packetID(); got != id {
+		t.Errorf("got PUBREL for packet %d, want %d", got, id)
+	}
+	b.send(t, ackPacket(packetPubComp, id))
+	expectDelivered(t, delivered)
+}
+
+func TestReconnect(t *testing.T) {
+	defer func(d time.Duration) { minBackoff = d }(minBackoff)
+	minBackoff = time.Millisecond * 10
+
+	m, brokers, delivered, _ := start(t, Options{Address: "broker:1883", QoS: 1})
+	b := accept(t, brokers)
+
+	// A message that wasn't acknowledged is published again with the DUP
+	// flag and the same packet id.
+	write(t, m)
+	_, id := b.expectPublish(t, topic)
+	b.conn.Close()
+
+	b = accept(t, brokers)
+	flags, got := b.expectPublish(t, topic)
+	if flags&0x08 == 0 {
+		t.Error("got no DUP flag on the published again message")
+	}
+	if got != id {
+		t.Errorf("got packet id %d, want %d", got, id)
+	}
+	b.send(t, ackPacket(packetPubAck, id))
+	expectDelivered(t, delivered)
+}
+
+func TestReconnectQoS2(t *testing.T) {
+	defer func(d time.Duration) { minBackoff = d }(minBackoff)
+	minBackoff = time.Millisecond * 10
+
+	m, brokers, delivered, _ := start(t, Options{Address: "broker:1883", QoS: 2})
+	b := accept(t, brokers)
+
+	// A message that the broker has received is only released after
+	// reconnecting, so that it isn't delivered twice.
+	write(t, m)
+	_, id := b.expectPublish(t, topic)
+	b.send(t, ackPacket(packetPubRec, id))
+	b.expect(t, packetPubRel)
+	b.conn.Close()
+
+	b = accept(t, brokers)
+	if got := b.expect(t, packetPubRel).packetID(); got != id {
+		t.Errorf("got PUBREL for packet %d, want %d", got, id)
+	}
+	b.send(t, ackPacket(packetPubComp, id))
+	expectDelivered(t, delivered)
+}
+
+func TestConnectRefused(t *testing.T) {
+	m, err := New(Options{Address: "broker:1883"})
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	client, conn := net.Pipe()
+	defer conn.Close()
+	m.dial = func(context.Context) (net.Conn, error) {
+		return client, nil
+	}
+
+	go func() {
+		if _, err := readPacket(bufio.NewReader(conn)); err == nil {
+			conn.Write([]byte{packetConnAck, 2, 0, 5})
+		}
+	}()
+
+	err = m.connect(context.Background(), slog.New(slog.DiscardHandler))
+	if err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("got error %v, want the connection to be refused as not authorized", err)
+	}
+
+	if err := m.Check(); err == nil {
+		t.Error("got no error after a refused connection")
+	}
+}
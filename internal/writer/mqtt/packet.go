@@ -0,0 +1,161 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	packetConnect    = 0x10
+	packetConnAck    = 0x20
+	packetPublish    = 0x30
+	packetPubAck     = 0x40
+	packetPubRec     = 0x50
+	packetPubRel     = 0x62
+	packetPubComp    = 0x70
+	packetPingReq    = 0xc0
+	packetPingResp   = 0xd0
+	packetDisconnect = 0xe0
+
+	protocolName  = "MQTT"
+	protocolLevel = 4
+
+	flagCleanSession = 0x02
+	flagPassword     = 0x40
+	flagUsername     = 0x80
+
+	maxRemainingLength = 268435455
+)
+
+var connectErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+type packet struct {
+	typ  byte
+	body []byte
+}
+
+func (p *packet) packetID() uint16 {
+	if len(p.body) < 2 {
+		return 0
+	}
+
+	return binary.BigEndian.Uint16(p.body)
+}
+
+func encode(typ byte, body []byte) []byte {
+	buf := []byte{typ}
+
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if n == 0 {
+			break
+		}
+	}
+
+	return append(buf, body...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func connectPacket(clientID, username, password string, keepAlive uint16) []byte {
+	var body []byte
+	body = appendString(body, protocolName)
+	body = append(body, protocolLevel)
+
+	flags := byte(flagCleanSession)
+	if username != "" {
+		flags |= flagUsername
+	}
+	if password != "" {
+		flags |= flagPassword
+	}
+	body = append(body, flags)
+	body = binary.BigEndian.AppendUint16(body, keepAlive)
+
+	body = appendString(body, clientID)
+	if username != "" {
+		body = appendString(body, username)
+	}
+	if password != "" {
+		body = appendString(body, password)
+	}
+
+	return encode(packetConnect, body)
+}
+
+func publishPacket(topic string, payload []byte, qos byte, retain, dup bool, id uint16) []byte {
+	typ := byte(packetPublish) | qos<<1
+	if retain {
+		typ |= 0x01
+	}
+	if dup {
+		typ |= 0x08
+	}
+
+	var body []byte
+	body = appendString(body, topic)
+	if qos > 0 {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	body = append(body, payload...)
+
+	return encode(typ, body)
+}
+
+func ackPacket(typ byte, id uint16) []byte {
+	return encode(typ, binary.BigEndian.AppendUint16(nil, id))
+}
+
+func readPacket(r *bufio.Reader) (*packet, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	var n, multiplier int = 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, errors.New("malformed remaining length")
+		}
+
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		n += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+
+	if n > maxRemainingLength {
+		return nil, fmt.Errorf("packet too large: %d bytes", n)
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	return &packet{typ: typ, body: body}, nil
+}
@@ -0,0 +1,300 @@
+package nats
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osm/qwbs/internal/version"
+	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/poster"
+)
+
+const (
+	dialTimeout = time.Second * 10
+	ioTimeout   = time.Second * 10
+	readTimeout = time.Minute * 5
+)
+
+type Options struct {
+	Address  string
+	TLS      bool
+	Username string
+	Password string
+	Token    string
+	Subject  string
+}
+
+type NATS struct {
+	opts Options
+	dial func(ctx context.Context) (net.Conn, error)
+
+	// sem guards the connection. Writes wait for it for at most ioTimeout,
+	// so that a stalled server fails broadcasts rather than piling them up.
+	sem  chan struct{}
+	conn *conn
+}
+
+type conn struct {
+	net.Conn
+	mu   sync.Mutex
+	err  error
+	done chan struct{}
+}
+
+type info struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+type connect struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version"`
+	Protocol  int    `json:"protocol"`
+	User      string `json:"user,omitempty"`
+	Pass      string `json:"pass,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+func New(opts Options) *NATS {
+	n := &NATS{opts: opts, sem: make(chan struct{}, 1)}
+	n.dial = n.dialServer
+
+	return n
+}
+
+func (n *NATS) Write(ctx context.Context, _ *slog.Logger, data *writer.Data) error {
+	payload, err := poster.EncodeJSON(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %w", err)
+	}
+
+	msg := fmt.Appendf(nil, "PUB %s %d\r\n", n.opts.Subject, len(payload))
+	msg = append(msg, payload...)
+	msg = append(msg, "\r\n"...)
+
+	// The connection, any reconnect and the publish share one deadline.
+	ctx, cancel := context.WithTimeout(ctx, ioTimeout)
+	defer cancel()
+
+	if err := n.lock(ctx); err != nil {
+		return fmt.Errorf("failed to publish to NATS %s: %w", n.opts.Address, err)
+	}
+	defer n.unlock()
+
+	// A broken connection is only detected when it's used, so retry once
+	// on a fresh connection before giving up.
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = n.publish(ctx, msg); err == nil {
+			break
+		}
+		n.close()
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to publish to NATS %s: %w", n.opts.Address, err)
+	}
+
+	return nil
+}
+
+func (n *NATS) Run(ctx context.Context, _ *slog.Logger) {
+	<-ctx.Done()
+
+	n.sem <- struct{}{}
+	defer n.unlock()
+	n.close()
+}
+
+func (n *NATS) Close() error {
+	n.sem <- struct{}{}
+	defer n.unlock()
+	n.close()
+
+	return nil
+}
+
+func (n *NATS) lock(ctx context.Context) error {
+	select {
+	case n.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for the connection: %w", ctx.Err())
+	}
+}
+
+func (n *NATS) unlock() {
+	<-n.sem
+}
+
+func (n *NATS) publish(ctx context.Context, msg []byte) error {
+	if n.conn == nil {
+		c, err := n.connect(ctx)
+		if err != nil {
+			return err
+		}
+		n.conn = c
+	}
+
+	select {
+	case <-n.conn.done:
+		return n.conn.err
+	default:
+	}
+
+	deadline, _ := ctx.Deadline()
+	return n.conn.send(msg, deadline)
+}
+
+func (n *NATS) close() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+}
+
+func (n *NATS) dialServer(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	return dialer.DialContext(ctx, "tcp", n.opts.Address)
+}
+
+func (n *NATS) connect(ctx context.Context) (*conn, error) {
+	nc, err := n.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(ioTimeout)
+	}
+
+	if err := nc.SetDeadline(deadline); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	r := bufio.NewReader(nc)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to read INFO: %w", err)
+	}
+
+	op, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if op != "INFO" {
+		nc.Close()
+		return nil, fmt.Errorf("unexpected message %q, expected INFO", line)
+	}
+
+	var srvInfo info
+	if err := json.Unmarshal([]byte(args), &srvInfo); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to decode INFO: %w", err)
+	}
+
+	if srvInfo.TLSRequired && !n.opts.TLS {
+		nc.Close()
+		return nil, fmt.Errorf("server requires TLS")
+	}
+
+	if n.opts.TLS {
+		host, _, _ := net.SplitHostPort(n.opts.Address)
+		tc := tls.Client(nc, &tls.Config{ServerName: host})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		nc = tc
+		r = bufio.NewReader(nc)
+	}
+
+	c := &conn{Conn: nc, done: make(chan struct{})}
+
+	opts, err := json.Marshal(&connect{
+		Name:      "qwbs",
+		Lang:      "go",
+		Version:   version.Short(),
+		Protocol:  1,
+		User:      n.opts.Username,
+		Pass:      n.opts.Password,
+		AuthToken: n.opts.Token,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	if err := c.send(fmt.Appendf(nil, "CONNECT %s\r\nPING\r\n", opts), deadline); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	// The server replies to the PING with a PONG once the connection has
+	// been accepted, or with an -ERR if authentication failed.
+	line, err = r.ReadString('\n')
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to read PONG: %w", err)
+	}
+
+	if line = strings.TrimSpace(line); line != "PONG" {
+		nc.Close()
+		return nil, fmt.Errorf("connection refused: %s", line)
+	}
+
+	go c.read(r)
+
+	return c, nil
+}
+
+func (c *conn) read(r *bufio.Reader) {
+	defer close(c.done)
+
+	for {
+		if err := c.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+			c.err = err
+			return
+		}
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		op, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch op {
+		case "PING":
+			if err := c.send([]byte("PONG\r\n"), time.Now().Add(ioTimeout)); err != nil {
+				c.err = err
+				return
+			}
+		case "-ERR":
+			c.err = errors.New(strings.Trim(args, "'"))
+			return
+		}
+	}
+}
+
+func (c *conn) send(b []byte, deadline time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+
+	_, err := c.Conn.Write(b)
+	return err
+}
@@ -0,0 +1,227 @@
+package nats
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osm/qwbs/internal/qw/broadcast"
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+const testTimeout = time.Second * 2
+
+// server is the server end of a piped connection.
+type server struct {
+	conn  net.Conn
+	lines chan string
+}
+
+// newNATS returns a client where every dial is connected to a new server,
+// which is sent on the returned channel.
+func newNATS(t *testing.T, opts Options) (*NATS, chan *server) {
+	t.Helper()
+
+	servers := make(chan *server, 10)
+	n := New(opts)
+	n.dial = func(context.Context) (net.Conn, error) {
+		client, conn := net.Pipe()
+		srv := &server{conn: conn, lines: make(chan string, 100)}
+		t.Cleanup(func() { conn.Close() })
+
+		go func() {
+			defer close(srv.lines)
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				srv.lines <- strings.TrimSuffix(scanner.Text(), "\r")
+			}
+		}()
+
+		servers <- srv
+		return client, nil
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		n.Run(ctx, nil)
+	})
+
+	return n, servers
+}
+
+func (s *server) send(t *testing.T, line string) {
+	t.Helper()
+
+	s.conn.SetWriteDeadline(time.Now().Add(testTimeout))
+	if _, err := io.WriteString(s.conn, line+"\r\n"); err != nil {
+		t.Errorf("failed to send %q: %v", line, err)
+	}
+}
+
+// next returns the next line sent by the client.
+func (s *server) next(t *testing.T) string {
+	t.Helper()
+
+	select {
+	case line, ok := <-s.lines:
+		if !ok {
+			t.Fatal("connection closed")
+		}
+		return line
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a line")
+	}
+
+	return ""
+}
+
+// accept waits for the next connection and replies to its handshake with
+// the given line.
+func accept(t *testing.T, servers chan *server, reply string) *server {
+	t.Helper()
+
+	var srv *server
+	select {
+	case srv = <-servers:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a connection")
+	}
+
+	srv.send(t, `INFO {"server_id":"test","tls_required":false}`)
+	if line := srv.next(t); !strings.HasPrefix(line, "CONNECT {") {
+		t.Errorf("got %q, want CONNECT", line)
+	}
+	if line := srv.next(t); line != "PING" {
+		t.Errorf("got %q, want PING", line)
+	}
+	srv.send(t, reply)
+
+	return srv
+}
+
+// write writes a broadcast in the background and returns its error on the
+// returned channel.
+func write(n *NATS) chan error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- n.Write(context.Background(), nil, &writer.Data{
+			Broadcast: &broadcast.Broadcast{Address: "qw.example.com:28501", Message: "need 2 more"},
+			Server:    &serverstatus.Server{Map: "dm3"},
+		})
+	}()
+
+	return errc
+}
+
+func wait(t *testing.T, errc chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the write")
+	}
+
+	return nil
+}
+
+// expectPublish checks that the next message is a publish of a broadcast to
+// the subject.
+func (s *server) expectPublish(t *testing.T, subject string) {
+	t.Helper()
+
+	if line := s.next(t); !strings.HasPrefix(line, "PUB "+subject+" ") {
+		t.Errorf("got %q, want PUB %s", line, subject)
+	}
+	if line := s.next(t); !strings.Contains(line, `"need 2 more"`) {
+		t.Errorf("got payload %q, want the broadcast", line)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	n, servers := newNATS(t, Options{Subject: "qwbs.broadcasts"})
+
+	errc := write(n)
+	srv := accept(t, servers, "PONG")
+	srv.expectPublish(t, "qwbs.broadcasts")
+
+	if err := wait(t, errc); err != nil {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestConnectError(t *testing.T) {
+	n, servers := newNATS(t, Options{Subject: "qwbs.broadcasts"})
+
+	errc := write(n)
+	accept(t, servers, "-ERR 'Authorization Violation'")
+	accept(t, servers, "-ERR 'Authorization Violation'")
+
+	err := wait(t, errc)
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("got error %v, want the connection to be refused", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	n, servers := newNATS(t, Options{Subject: "qwbs.broadcasts"})
+
+	errc := write(n)
+	srv := accept(t, servers, "PONG")
+	srv.expectPublish(t, "qwbs.broadcasts")
+	wait(t, errc)
+
+	srv.send(t, "PING")
+	if line := srv.next(t); line != "PONG" {
+		t.Errorf("got %q, want PONG", line)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	n, servers := newNATS(t, Options{Subject: "qwbs.broadcasts"})
+
+	errc := write(n)
+	srv := accept(t, servers, "PONG")
+	srv.expectPublish(t, "qwbs.broadcasts")
+	wait(t, errc)
+
+	// An error from the server ends the connection, so the next broadcast
+	// is published on a fresh one.
+	srv.send(t, "-ERR 'Stale Connection'")
+	<-n.conn.done
+
+	errc = write(n)
+	srv = accept(t, servers, "PONG")
+	srv.expectPublish(t, "qwbs.broadcasts")
+
+	if err := wait(t, errc); err != nil {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestWriteBusy(t *testing.T) {
+	n, _ := newNATS(t, Options{Subject: "qwbs.broadcasts"})
+
+	// A write waiting for a stalled one gives up with its context.
+	n.sem <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	err := n.Write(ctx, nil, &writer.Data{
+		Broadcast: &broadcast.Broadcast{},
+		Server:    &serverstatus.Server{},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want the wait to time out", err)
+	}
+
+	n.unlock()
+}
@@ -0,0 +1,162 @@
+package poster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/osm/qwbs/internal/tracing"
+)
+
+const (
+	maxAttempts   = 3
+	retryBackoff  = time.Second
+	maxRetryAfter = time.Minute
+)
+
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("received an unexpected response: %s", e.Status)
+}
+
+// RetryAfterFunc returns how long to wait before retrying based on the body
+// of a rejected response, for APIs that don't use the Retry-After header.
+type RetryAfterFunc func(respBody []byte) time.Duration
+
+// SignFunc adds a signature of the body to the header of a request.
+type SignFunc func(header http.Header, body []byte)
+
+type Client struct {
+	client     *http.Client
+	retryAfter RetryAfterFunc
+	sign       SignFunc
+}
+
+func NewClient() *Client {
+	return &Client{client: &http.Client{Timeout: timeout}}
+}
+
+// WithRetryAfter sets the function used to find the wait before a retry in
+// the response body when the Retry-After header is missing.
+func (c *Client) WithRetryAfter(f RetryAfterFunc) *Client {
+	c.retryAfter = f
+	return c
+}
+
+// WithSign sets the function used to sign requests. It's called before every
+// attempt, so that signatures that include the time are fresh on retries.
+func (c *Client) WithSign(f SignFunc) *Client {
+	c.sign = f
+	return c
+}
+
+func (c *Client) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
+
+func (c *Client) Do(ctx context.Context, method, url string, header http.Header, body []byte) ([]byte, error) {
+	var err error
+	var respBody []byte
+	var wait time.Duration
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		respBody, wait, err = c.do(ctx, method, url, header, body)
+		if err == nil || wait < 0 || attempt == maxAttempts {
+			break
+		}
+
+		if wait == 0 {
+			wait = retryBackoff << (attempt - 1)
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return respBody, errors.Join(err, ctx.Err())
+		}
+	}
+
+	return respBody, err
+}
+
+// do performs a single request and returns how long to wait before retrying,
+// where a negative duration means that the request shouldn't be retried.
+func (c *Client) do(ctx context.Context, method, url string, header http.Header, body []byte) ([]byte, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	tracing.Inject(ctx, req.Header)
+
+	if c.sign != nil {
+		c.sign(req.Header, body)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, -1, err
+		}
+		return nil, 0, fmt.Errorf("failed to perform HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read HTTP response body: %w", err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return respBody, 0, nil
+	}
+
+	statusErr := &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       respBody,
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return respBody, -1, statusErr
+	}
+
+	wait := retryAfter(resp.Header.Get("Retry-After"))
+	if wait == 0 && c.retryAfter != nil {
+		wait = min(max(c.retryAfter(respBody), 0), maxRetryAfter)
+	}
+
+	return respBody, wait, statusErr
+}
+
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	var wait time.Duration
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		wait = time.Duration(secs * float64(time.Second))
+	} else if t, err := http.ParseTime(value); err == nil {
+		wait = time.Until(t)
+	}
+
+	if wait <= 0 {
+		return 0
+	}
+
+	return min(wait, maxRetryAfter)
+}
@@ -6,59 +6,181 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
+	"unicode/utf8"
 
-	"github.com/osm/qwbs/internal/qw/serverstatus"
 	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/textfmt"
 )
 
+const (
+	discordMaxContent          = 2000
+	discordMaxUsername         = 80
+	discordMaxEmbedTitle       = 256
+	discordMaxEmbedDescription = 4096
+	discordMaxEmbedFields      = 25
+	discordMaxEmbedFieldName   = 256
+	discordMaxEmbedFieldValue  = 1024
+	discordMaxEmbedFooter      = 2048
+	discordMaxEmbedTotal       = 6000
+	discordDefaultColor        = 0x95a5a6
+)
+
+var discordModeColors = map[string]int{
+	"1on1": 0x3498db,
+	"2on2": 0x2ecc71,
+	"4on4": 0xe74c3c,
+	"ctf":  0x9b59b6,
+	"ffa":  0xe67e22,
+}
+
 type DiscordPayload struct {
-	Content string         `json:"content"`
-	Embeds  []DiscordEmbed `json:"embeds"`
+	Content   string         `json:"content"`
+	Username  string         `json:"username,omitempty"`
+	AvatarURL string         `json:"avatar_url,omitempty"`
+	Embeds    []DiscordEmbed `json:"embeds"`
 }
 
 type DiscordEmbed struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	Title       string              `json:"title"`
+	Description string              `json:"description"`
+	URL         string              `json:"url,omitempty"`
+	Color       int                 `json:"color,omitempty"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+	Footer      *DiscordEmbedFooter `json:"footer,omitempty"`
+	Fields      []DiscordEmbedField `json:"fields,omitempty"`
+}
+
+type DiscordEmbedFooter struct {
+	Text string `json:"text"`
+}
+
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
 }
 
-func formatDiscord(data *writer.Data) (io.Reader, string, error) {
+func formatDiscord(opts *Options, data *writer.Data) (io.Reader, string, error) {
+	jsonData, err := json.Marshal(newDiscordPayload(opts, data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return bytes.NewBuffer(jsonData), contentTypeJSON, nil
+}
+
+func newDiscordPayload(opts *Options, data *writer.Data) *DiscordPayload {
 	bc := data.Broadcast
 	sv := data.Server
-	pl := data.Server.Players
-
-	playerNames := func(players []serverstatus.Player) string {
-		n := len(players)
-		switch n {
-		case 0:
-			return ""
-		case 1:
-			return players[0].Name
-		case 2:
-			return players[0].Name + " and " + players[1].Name
-		default:
-			names := make([]string, n)
-			for i, p := range players {
-				names[i] = p.Name
-			}
-			return strings.Join(names[:n-1], ", ") + " and " + names[n-1]
+
+	embed := DiscordEmbed{
+		Title: fmt.Sprintf("%s/%s @ %s | %s",
+			data.Players(), data.MaxPlayers(), sv.Map, bc.Address),
+		Description: textfmt.List(sv.Playing()),
+		Color:       discordColor(sv.Mode),
+		Footer:      &DiscordEmbedFooter{Text: bc.Address},
+		Fields: []DiscordEmbedField{
+			{Name: "Map", Value: sv.Map, Inline: true},
+			{Name: "Mode", Value: sv.Mode, Inline: true},
+			{Name: "Players", Value: data.Players() + "/" + data.MaxPlayers(), Inline: true},
+		},
+	}
+
+	if !data.Time.IsZero() {
+		embed.Timestamp = data.Time.UTC().Format(time.RFC3339)
+	}
+
+	if opts.ConnectURL != "" {
+		embed.URL = opts.ConnectURL + bc.Address
+	}
+
+	teams := sv.Teams()
+	if len(teams) > 1 {
+		for _, t := range teams {
+			embed.Fields = append(embed.Fields, DiscordEmbedField{
+				Name:   fmt.Sprintf("Team %s (%d)", t.Name, len(t.Players)),
+				Value:  textfmt.List(t.Players),
+				Inline: true,
+			})
 		}
 	}
 
-	payload := DiscordPayload{
-		Content: fmt.Sprintf("**%s**: %s", bc.Name, bc.Message),
-		Embeds: []DiscordEmbed{
-			{
-				Title: fmt.Sprintf("%s/%s @ %s | %s",
-					data.Players(), data.MaxPlayers(), sv.Map, bc.Address),
-				Description: playerNames(pl),
-			},
-		},
+	if spectators := sv.Spectators(); len(spectators) > 0 {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{
+			Name:  fmt.Sprintf("Spectators (%d)", len(spectators)),
+			Value: textfmt.List(spectators),
+		})
 	}
 
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return nil, "", err
+	embed.Fields = append(embed.Fields, DiscordEmbedField{
+		Name:  "Connect",
+		Value: fmt.Sprintf("`connect %s`", bc.Address),
+	})
+
+	truncateDiscordEmbed(&embed)
+
+	return &DiscordPayload{
+		Content:   textfmt.Truncate(fmt.Sprintf("**%s**: %s", bc.Name, bc.Message), discordMaxContent),
+		Username:  textfmt.Truncate(opts.Username, discordMaxUsername),
+		AvatarURL: opts.AvatarURL,
+		Embeds:    []DiscordEmbed{embed},
 	}
+}
 
-	return bytes.NewBuffer(jsonData), contentTypeJSON, nil
+func discordColor(mode string) int {
+	if c, ok := discordModeColors[strings.ToLower(mode)]; ok {
+		return c
+	}
+
+	return discordDefaultColor
+}
+
+func truncateDiscordEmbed(e *DiscordEmbed) {
+	e.Title = textfmt.Truncate(e.Title, discordMaxEmbedTitle)
+	e.Description = textfmt.Truncate(e.Description, discordMaxEmbedDescription)
+
+	if e.Footer != nil {
+		e.Footer.Text = textfmt.Truncate(e.Footer.Text, discordMaxEmbedFooter)
+	}
+
+	if len(e.Fields) > discordMaxEmbedFields {
+		e.Fields = e.Fields[:discordMaxEmbedFields]
+	}
+
+	for i := range e.Fields {
+		e.Fields[i].Name = textfmt.Truncate(e.Fields[i].Name, discordMaxEmbedFieldName)
+		e.Fields[i].Value = textfmt.Truncate(e.Fields[i].Value, discordMaxEmbedFieldValue)
+
+		// Discord rejects fields with empty names or values.
+		if e.Fields[i].Name == "" {
+			e.Fields[i].Name = "-"
+		}
+		if e.Fields[i].Value == "" {
+			e.Fields[i].Value = "-"
+		}
+	}
+
+	for discordEmbedLength(e) > discordMaxEmbedTotal && len(e.Fields) > 0 {
+		e.Fields = e.Fields[:len(e.Fields)-1]
+	}
+
+	if n := discordEmbedLength(e); n > discordMaxEmbedTotal {
+		limit := utf8.RuneCountInString(e.Description) - (n - discordMaxEmbedTotal)
+		e.Description = textfmt.Truncate(e.Description, limit)
+	}
+}
+
+func discordEmbedLength(e *DiscordEmbed) int {
+	n := utf8.RuneCountInString(e.Title) + utf8.RuneCountInString(e.Description)
+
+	if e.Footer != nil {
+		n += utf8.RuneCountInString(e.Footer.Text)
+	}
+
+	for _, f := range e.Fields {
+		n += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+	}
+
+	return n
 }
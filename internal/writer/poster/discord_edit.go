@@ -0,0 +1,275 @@
+package poster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+const (
+	discordFinalColor = 0x2c2f33
+	finalizeTimeout   = time.Second * 30
+)
+
+// discordMessage is the message posted for a server. Its mutex serializes the
+// requests for the server, so that the requests for other servers aren't held
+// up by a slow webhook. The generation is increased on every edit, which lets
+// a finalize timer that fired concurrently with an edit detect that it's
+// stale.
+type discordMessage struct {
+	mu         sync.Mutex
+	id         string
+	data       *writer.Data
+	generation uint64
+	timer      *time.Timer
+	removed    bool
+}
+
+type discordMessageResponse struct {
+	ID string `json:"id"`
+}
+
+func (p *Poster) writeDiscordEdit(ctx context.Context, logger *slog.Logger, data *writer.Data) error {
+	addr := data.Broadcast.Address
+
+	msg := p.lockDiscordMessage(addr)
+	defer msg.mu.Unlock()
+
+	if msg.id != "" {
+		msg.timer.Stop()
+
+		err := p.editDiscordMessage(ctx, msg.id, newDiscordPayload(p.opts, data))
+		if err == nil {
+			msg.data = data
+			p.finalizeAfter(logger, addr, msg)
+			return nil
+		}
+
+		logger.Error("Failed to edit Discord message, posting a new one",
+			"address", addr, "id", msg.id, "error", err)
+
+		// The previous message is finalized rather than left as if it
+		// could still be edited.
+		p.finalizeDiscordMessage(ctx, logger, msg.id, msg.data)
+		msg.id = ""
+	}
+
+	id, err := p.postDiscordMessage(ctx, newDiscordPayload(p.opts, data))
+	if err != nil {
+		p.removeDiscordMessage(addr, msg)
+		return fmt.Errorf("failed to post data: %w", err)
+	}
+
+	msg.id = id
+	msg.data = data
+	p.finalizeAfter(logger, addr, msg)
+	return nil
+}
+
+// lockDiscordMessage returns the locked message of the server, a new one is
+// added if there is none or the existing one is being removed.
+func (p *Poster) lockDiscordMessage(addr string) *discordMessage {
+	for {
+		p.mu.Lock()
+		msg, ok := p.messages[addr]
+		if !ok {
+			msg = &discordMessage{}
+			p.messages[addr] = msg
+		}
+		p.mu.Unlock()
+
+		msg.mu.Lock()
+		if !msg.removed {
+			return msg
+		}
+		msg.mu.Unlock()
+	}
+}
+
+// removeDiscordMessage removes the message of the server, the caller must
+// hold the message lock.
+func (p *Poster) removeDiscordMessage(addr string, msg *discordMessage) {
+	msg.removed = true
+	if msg.timer != nil {
+		msg.timer.Stop()
+	}
+
+	p.mu.Lock()
+	if p.messages[addr] == msg {
+		delete(p.messages, addr)
+	}
+	p.mu.Unlock()
+}
+
+// finalizeAfter schedules the message to be finalized once the edit window
+// has passed, the caller must hold the message lock.
+func (p *Poster) finalizeAfter(logger *slog.Logger, addr string, msg *discordMessage) {
+	msg.generation++
+	generation := msg.generation
+
+	msg.timer = time.AfterFunc(p.opts.EditWindow, func() {
+		msg.mu.Lock()
+		if msg.removed || msg.generation != generation {
+			msg.mu.Unlock()
+			return
+		}
+
+		id, data := msg.id, msg.data
+		p.removeDiscordMessage(addr, msg)
+		msg.mu.Unlock()
+
+		p.finalizeDiscordMessage(context.Background(), logger, id, data)
+	})
+}
+
+// Run finalizes the messages that are still within their edit window when
+// the writer is stopped.
+func (p *Poster) Run(ctx context.Context, logger *slog.Logger) {
+	if p.format != Discord || p.opts.EditWindow <= 0 {
+		return
+	}
+
+	<-ctx.Done()
+
+	// The messages are finalized after the writer has been stopped, so
+	// the requests are bounded by finalizeTimeout rather than ctx.
+	ctx = context.WithoutCancel(ctx)
+
+	p.mu.Lock()
+	messages := maps.Clone(p.messages)
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for addr, msg := range messages {
+		msg.mu.Lock()
+		if msg.removed || msg.id == "" {
+			msg.mu.Unlock()
+			continue
+		}
+
+		id, data := msg.id, msg.data
+		p.removeDiscordMessage(addr, msg)
+		msg.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.finalizeDiscordMessage(ctx, logger, id, data)
+		}()
+	}
+	wg.Wait()
+}
+
+// finalizeDiscordMessage marks the message as started or expired, the server
+// status query and the edit are bounded by ctx and finalizeTimeout.
+func (p *Poster) finalizeDiscordMessage(ctx context.Context, logger *slog.Logger, id string, data *writer.Data) {
+	addr := data.Broadcast.Address
+
+	ctx, cancel := context.WithTimeout(ctx, finalizeTimeout)
+	defer cancel()
+
+	state := "Expired"
+	if started(ctx, data) {
+		state = "Game started"
+	}
+
+	payload := newDiscordPayload(p.opts, data)
+	payload.Content = fmt.Sprintf("~~%s~~ (%s)", payload.Content, state)
+	for i := range payload.Embeds {
+		payload.Embeds[i].Color = discordFinalColor
+		payload.Embeds[i].Footer = &DiscordEmbedFooter{Text: addr + " | " + state}
+	}
+
+	if err := p.editDiscordMessage(ctx, id, payload); err != nil {
+		logger.Error("Failed to finalize Discord message",
+			"address", addr, "id", id, "error", err)
+	}
+}
+
+func (p *Poster) postDiscordMessage(ctx context.Context, payload *DiscordPayload) (string, error) {
+	u, err := discordURL(p.url, "", true)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	respBody, err := p.send(ctx, http.MethodPost, u, bytes.NewReader(body), contentTypeJSON)
+	if err != nil {
+		return "", err
+	}
+
+	var msg discordMessageResponse
+	if err := json.Unmarshal(respBody, &msg); err != nil {
+		return "", fmt.Errorf("failed to decode Discord response: %w", err)
+	}
+
+	if msg.ID == "" {
+		return "", fmt.Errorf("no message id in Discord response")
+	}
+
+	return msg.ID, nil
+}
+
+func (p *Poster) editDiscordMessage(ctx context.Context, id string, payload *DiscordPayload) error {
+	u, err := discordURL(p.url, "/messages/"+url.PathEscape(id), false)
+	if err != nil {
+		return err
+	}
+
+	// Webhook username and avatar can't be changed when editing a message.
+	payload.Username = ""
+	payload.AvatarURL = ""
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.send(ctx, http.MethodPatch, u, bytes.NewReader(body), contentTypeJSON)
+	return err
+}
+
+func discordURL(webhook, suffix string, wait bool) (string, error) {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return "", fmt.Errorf("invalid webhook url: %w", err)
+	}
+
+	u.Path += suffix
+	if wait {
+		q := u.Query()
+		q.Set("wait", "true")
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String(), nil
+}
+
+func started(ctx context.Context, data *writer.Data) bool {
+	sd, err := serverstatus.QueryContext(ctx, data.Broadcast.Address)
+	if err != nil {
+		return false
+	}
+
+	maxPlayers, err := strconv.Atoi(data.MaxPlayers())
+	if err != nil || maxPlayers == 0 {
+		return false
+	}
+
+	return len(sd.Playing()) >= maxPlayers
+}
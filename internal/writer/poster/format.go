@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
+	"text/template"
 
 	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/tmpl"
 )
 
 type Format uint8
@@ -15,13 +18,15 @@ const (
 	Unknown Format = iota
 	Discord
 	JSON
+	Template
 	Text
 )
 
 var formatMap = map[string]Format{
-	"discord": Discord,
-	"json":    JSON,
-	"text":    Text,
+	"discord":  Discord,
+	"json":     JSON,
+	"template": Template,
+	"text":     Text,
 }
 
 func ValidateFormat(format string) (Format, error) {
@@ -33,12 +38,14 @@ func ValidateFormat(format string) (Format, error) {
 	return f, nil
 }
 
-func format(format Format, data *writer.Data) (io.Reader, string, error) {
+func format(format Format, opts *Options, data *writer.Data) (io.Reader, string, error) {
 	switch format {
 	case Discord:
-		return formatDiscord(data)
+		return formatDiscord(opts, data)
 	case JSON:
 		return formatJSON(data)
+	case Template:
+		return formatTemplate(opts.Template, data)
 	case Text:
 		return formatText(data)
 	}
@@ -46,8 +53,12 @@ func format(format Format, data *writer.Data) (io.Reader, string, error) {
 	return nil, "", fmt.Errorf("unknown format: %q", format)
 }
 
+func EncodeJSON(data *writer.Data) ([]byte, error) {
+	return json.Marshal(data)
+}
+
 func formatJSON(data *writer.Data) (io.Reader, string, error) {
-	payload, err := json.Marshal(data)
+	payload, err := EncodeJSON(data)
 	if err != nil {
 		return nil, "", err
 	}
@@ -62,3 +73,21 @@ func formatText(data *writer.Data) (io.Reader, string, error) {
 
 	return bytes.NewBufferString(payload), contentTypeText, nil
 }
+
+func formatTemplate(t *template.Template, data *writer.Data) (io.Reader, string, error) {
+	if t == nil {
+		return nil, "", fmt.Errorf("no template configured")
+	}
+
+	payload, err := tmpl.Execute(t, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType := contentTypeText
+	if strings.HasSuffix(t.Name(), ".json") {
+		contentType = contentTypeJSON
+	}
+
+	return bytes.NewBufferString(payload), contentType, nil
+}
@@ -2,9 +2,18 @@ package poster
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/osm/qwbs/internal/writer"
@@ -14,48 +23,144 @@ const (
 	contentTypeJSON = "application/json"
 	contentTypeText = "text/plain"
 	timeout         = time.Second * 10
+	signatureHeader = "X-Qwbs-Signature"
+	timestampHeader = "X-Qwbs-Timestamp"
 )
 
+type Options struct {
+	Template   *template.Template
+	Username   string
+	AvatarURL  string
+	ConnectURL string
+	EditWindow time.Duration
+
+	Method        string
+	Header        http.Header
+	BearerToken   string
+	BasicUser     string
+	BasicPassword string
+	HMACSecret    string
+}
+
 type Poster struct {
 	url    string
 	format Format
-	client *http.Client
+	opts   *Options
+	client *Client
+
+	mu       sync.Mutex
+	messages map[string]*discordMessage
 }
 
-func New(url string, format Format) *Poster {
-	return &Poster{
-		url:    url,
-		format: format,
-		client: &http.Client{Timeout: timeout},
+func New(url string, format Format, opts *Options) *Poster {
+	if opts == nil {
+		opts = &Options{}
 	}
+
+	if opts.Method == "" {
+		opts.Method = http.MethodPost
+	}
+
+	p := &Poster{
+		url:      url,
+		format:   format,
+		opts:     opts,
+		client:   NewClient(),
+		messages: make(map[string]*discordMessage),
+	}
+
+	if opts.HMACSecret != "" {
+		p.client.WithSign(p.sign)
+	}
+
+	return p
 }
 
-func (p *Poster) Write(ctx context.Context, logger *slog.Logger, data *writer.Data) {
-	body, contentType, err := format(p.format, data)
-	if err != nil {
-		logger.Error("Failed to format data", "error", err)
-		return
+func (p *Poster) Write(ctx context.Context, logger *slog.Logger, data *writer.Data) error {
+	if p.format == Discord && p.opts.EditWindow > 0 {
+		return p.writeDiscordEdit(ctx, logger, data)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", p.url, body)
+	body, contentType, err := format(p.format, p.opts, data)
 	if err != nil {
-		logger.Error("Failed to create HTTP request", "url", p.url, "error", err)
-		return
+		return fmt.Errorf("failed to format data: %w", err)
+	}
+
+	if _, err := p.send(ctx, p.opts.Method, p.url, body, contentType); err != nil {
+		return fmt.Errorf("failed to post data: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Poster) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
+
+// Probe checks that the URL is reachable without posting anything. Discord
+// webhooks can be fetched, other endpoints are only expected to respond to a
+// HEAD request without a server or authorization error.
+func (p *Poster) Probe(ctx context.Context) error {
+	if p.format == Discord {
+		_, err := p.client.Do(ctx, http.MethodGet, p.url, nil, nil)
+		return err
 	}
-	req.Header.Set("Content-Type", contentType)
 
-	resp, err := p.client.Do(req)
+	_, err := p.client.Do(ctx, http.MethodHead, p.url, p.header(contentTypeText), nil)
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode < http.StatusInternalServerError &&
+		statusErr.StatusCode != http.StatusUnauthorized && statusErr.StatusCode != http.StatusForbidden {
+		return nil
+	}
+
+	return err
+}
+
+func (p *Poster) send(ctx context.Context, method, url string, body io.Reader, contentType string) ([]byte, error) {
+	payload, err := io.ReadAll(body)
 	if err != nil {
-		logger.Error("Failed to perform HTTP request", "url", p.url, "error", err)
-		return
+		return nil, fmt.Errorf("failed to read request body: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		logger.Error("Received an unexpected response", "url", p.url, "status", resp.Status)
+	return p.client.Do(ctx, method, url, p.header(contentType), payload)
+}
+
+func (p *Poster) header(contentType string) http.Header {
+	header := p.opts.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("Content-Type", contentType)
+
+	if p.opts.BearerToken != "" {
+		header.Set("Authorization", "Bearer "+p.opts.BearerToken)
 	}
 
-	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
-		logger.Error("Failed to drain HTTP response body", "url", p.url, "error", err)
+	if p.opts.BasicUser != "" {
+		auth := p.opts.BasicUser + ":" + p.opts.BasicPassword
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
 	}
+
+	return header
+}
+
+// sign sets the timestamp and signature headers of a request. It's called
+// for every attempt, so retries carry the time they were made.
+func (p *Poster) sign(header http.Header, body []byte) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	header.Set(timestampHeader, ts)
+	header.Set(signatureHeader, "sha256="+sign(p.opts.HMACSecret, ts, body))
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the timestamp and body joined
+// by a dot, binding the signature to the time the request was made so that
+// receivers can reject replayed requests.
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
 }
@@ -0,0 +1,51 @@
+package poster
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/osm/qwbs/internal/qw/broadcast"
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+func TestWriteSignRetry(t *testing.T) {
+	var timestamps []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ts := r.Header.Get(timestampHeader)
+		if got, want := r.Header.Get(signatureHeader), "sha256="+sign("secret", ts, body); got != want {
+			t.Errorf("got signature %q, want %q", got, want)
+		}
+
+		timestamps = append(timestamps, ts)
+		if len(timestamps) == 1 {
+			// Wait long enough for the retry to get a new timestamp.
+			w.Header().Set("Retry-After", "1.1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	p := New(srv.URL, JSON, &Options{HMACSecret: "secret"})
+	data := &writer.Data{
+		Broadcast: &broadcast.Broadcast{Address: "qw.example.com:28501", Name: "Bob", Message: "hi"},
+		Server:    &serverstatus.Server{Map: "dm3"},
+	}
+
+	if err := p.Write(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), data); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if len(timestamps) != 2 {
+		t.Fatalf("got %d requests, want the request to be retried once", len(timestamps))
+	}
+
+	if timestamps[0] == timestamps[1] {
+		t.Errorf("got timestamp %s on both attempts, want the retry to be signed again", timestamps[0])
+	}
+}
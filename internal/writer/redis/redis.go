@@ -0,0 +1,263 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/poster"
+)
+
+const (
+	dialTimeout = time.Second * 10
+	ioTimeout   = time.Second * 10
+	streamField = "data"
+)
+
+type Options struct {
+	Address  string
+	TLS      bool
+	Username string
+	Password string
+	DB       int
+	Channel  string
+	Stream   string
+	MaxLen   int
+}
+
+type Redis struct {
+	opts Options
+	dial func(ctx context.Context) (net.Conn, error)
+
+	// sem guards the connection. Writes wait for it for at most ioTimeout,
+	// so that a stalled server fails broadcasts rather than piling them up.
+	sem  chan struct{}
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+func New(opts Options) *Redis {
+	r := &Redis{opts: opts, sem: make(chan struct{}, 1)}
+	r.dial = r.dialServer
+
+	return r
+}
+
+func (r *Redis) Write(ctx context.Context, _ *slog.Logger, data *writer.Data) error {
+	payload, err := poster.EncodeJSON(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %w", err)
+	}
+
+	args := []string{"PUBLISH", r.opts.Channel, string(payload)}
+	if r.opts.Stream != "" {
+		args = []string{"XADD", r.opts.Stream}
+		if r.opts.MaxLen > 0 {
+			args = append(args, "MAXLEN", "~", strconv.Itoa(r.opts.MaxLen))
+		}
+		args = append(args, "*", streamField, string(payload))
+	}
+
+	// The connection, any reconnect and the command share one deadline.
+	ctx, cancel := context.WithTimeout(ctx, ioTimeout)
+	defer cancel()
+
+	if err := r.lock(ctx); err != nil {
+		return fmt.Errorf("failed to publish to Redis %s: %w", r.opts.Address, err)
+	}
+	defer r.unlock()
+
+	// A broken connection is only detected when it's used, so retry once
+	// on a fresh connection before giving up.
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err = r.do(ctx, args...)
+
+		var redisErr Error
+		if err == nil || errors.As(err, &redisErr) {
+			break
+		}
+		r.close()
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to publish to Redis %s: %w", r.opts.Address, err)
+	}
+
+	return nil
+}
+
+func (r *Redis) Run(ctx context.Context, _ *slog.Logger) {
+	<-ctx.Done()
+
+	r.sem <- struct{}{}
+	defer r.unlock()
+	r.close()
+}
+
+func (r *Redis) Close() error {
+	r.sem <- struct{}{}
+	defer r.unlock()
+	r.close()
+
+	return nil
+}
+
+func (r *Redis) lock(ctx context.Context) error {
+	select {
+	case r.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for the connection: %w", ctx.Err())
+	}
+}
+
+func (r *Redis) unlock() {
+	<-r.sem
+}
+
+func (r *Redis) do(ctx context.Context, args ...string) (any, error) {
+	if r.conn == nil {
+		if err := r.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.command(ctx, args...)
+}
+
+func (r *Redis) dialServer(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if !r.opts.TLS {
+		return dialer.DialContext(ctx, "tcp", r.opts.Address)
+	}
+
+	host, _, _ := net.SplitHostPort(r.opts.Address)
+	return (&tls.Dialer{
+		NetDialer: dialer,
+		Config:    &tls.Config{ServerName: host},
+	}).DialContext(ctx, "tcp", r.opts.Address)
+}
+
+func (r *Redis) connect(ctx context.Context) error {
+	conn, err := r.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.conn = conn
+	r.r = bufio.NewReader(conn)
+
+	if r.opts.Password != "" {
+		args := []string{"AUTH", r.opts.Password}
+		if r.opts.Username != "" {
+			args = []string{"AUTH", r.opts.Username, r.opts.Password}
+		}
+
+		if _, err := r.command(ctx, args...); err != nil {
+			r.close()
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	if r.opts.DB != 0 {
+		if _, err := r.command(ctx, "SELECT", strconv.Itoa(r.opts.DB)); err != nil {
+			r.close()
+			return fmt.Errorf("failed to select database %d: %w", r.opts.DB, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Redis) close() {
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+		r.r = nil
+	}
+}
+
+func (r *Redis) command(ctx context.Context, args ...string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(ioTimeout)
+	}
+
+	if err := r.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+
+	if _, err := r.conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return readReply(r.r)
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply: %q", line)
+	}
+
+	typ, value := line[0], line[1:len(line)-2]
+	switch typ {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, Error(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		values := make([]any, n)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unknown reply type %q", typ)
+	}
+}
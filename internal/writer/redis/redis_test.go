@@ -0,0 +1,216 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osm/qwbs/internal/qw/broadcast"
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+const testTimeout = time.Second * 2
+
+// server is the server end of a piped connection.
+type server struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newRedis returns a client where every dial is connected to a new server,
+// which is sent on the returned channel.
+func newRedis(t *testing.T, opts Options) (*Redis, chan *server) {
+	t.Helper()
+
+	servers := make(chan *server, 10)
+	r := New(opts)
+	r.dial = func(context.Context) (net.Conn, error) {
+		client, conn := net.Pipe()
+		t.Cleanup(func() { conn.Close() })
+		servers <- &server{conn: conn, r: bufio.NewReader(conn)}
+		return client, nil
+	}
+
+	return r, servers
+}
+
+// serve answers the commands of the next connection with the replies in
+// order and returns the commands it received. The connection is closed
+// once the replies run out.
+func serve(t *testing.T, servers chan *server, replies ...string) chan []string {
+	t.Helper()
+
+	commands := make(chan []string, len(replies))
+	go func() {
+		defer close(commands)
+
+		var srv *server
+		select {
+		case srv = <-servers:
+		case <-time.After(testTimeout):
+			return
+		}
+		defer srv.conn.Close()
+
+		for _, reply := range replies {
+			srv.conn.SetDeadline(time.Now().Add(testTimeout))
+			args, err := readReply(srv.r)
+			if err != nil {
+				return
+			}
+
+			var command []string
+			for _, arg := range args.([]any) {
+				command = append(command, arg.(string))
+			}
+			commands <- command
+
+			if _, err := srv.conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}()
+
+	return commands
+}
+
+func collect(commands chan []string) [][]string {
+	var all [][]string
+	for c := range commands {
+		all = append(all, c)
+	}
+
+	return all
+}
+
+func write(r *Redis) error {
+	data := &writer.Data{
+		Broadcast: &broadcast.Broadcast{Address: "qw.example.com:28501", Message: "need 2 more"},
+		Server:    &serverstatus.Server{Map: "dm3"},
+	}
+
+	return r.Write(context.Background(), nil, data)
+}
+
+func TestWrite(t *testing.T) {
+	r, servers := newRedis(t, Options{Username: "qwbs", Password: "secret", DB: 2, Channel: "qwbs"})
+	commands := serve(t, servers, "+OK\r\n", "+OK\r\n", ":1\r\n")
+
+	if err := write(r); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	r.close()
+
+	got := collect(commands)
+	if len(got) != 3 {
+		t.Fatalf("got commands %q, want AUTH, SELECT and PUBLISH", got)
+	}
+
+	want := [][]string{{"AUTH", "qwbs", "secret"}, {"SELECT", "2"}}
+	if !reflect.DeepEqual(got[:2], want) {
+		t.Errorf("got commands %q, want %q", got[:2], want)
+	}
+
+	if publish := got[2]; len(publish) != 3 || publish[0] != "PUBLISH" || publish[1] != "qwbs" ||
+		!strings.Contains(publish[2], `"need 2 more"`) {
+		t.Errorf("got command %q, want a PUBLISH of the broadcast to qwbs", publish)
+	}
+}
+
+func TestWriteStream(t *testing.T) {
+	r, servers := newRedis(t, Options{Stream: "broadcasts", MaxLen: 1000})
+	commands := serve(t, servers, "$15\r\n1700000000000-0\r\n")
+
+	if err := write(r); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	r.close()
+
+	got := collect(commands)
+	if len(got) != 1 || len(got[0]) != 8 {
+		t.Fatalf("got commands %q, want one XADD", got)
+	}
+
+	want := []string{"XADD", "broadcasts", "MAXLEN", "~", "1000", "*", streamField}
+	if !reflect.DeepEqual(got[0][:7], want) {
+		t.Errorf("got command %q, want it to start with %q", got[0], want)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	r, servers := newRedis(t, Options{Channel: "qwbs"})
+	serve(t, servers, "-ERR wrong number of arguments\r\n")
+
+	err := write(r)
+
+	// Errors from the server are returned without reconnecting.
+	var redisErr Error
+	if !errors.As(err, &redisErr) || redisErr != "ERR wrong number of arguments" {
+		t.Fatalf("got error %v, want the server error", err)
+	}
+
+	select {
+	case <-servers:
+		t.Error("got a reconnect after a server error")
+	default:
+	}
+}
+
+func TestWriteAuthError(t *testing.T) {
+	r, servers := newRedis(t, Options{Password: "wrong", Channel: "qwbs"})
+	serve(t, servers, "-WRONGPASS invalid username-password pair\r\n")
+
+	if err := write(r); err == nil || !strings.Contains(err.Error(), "failed to authenticate") {
+		t.Errorf("got error %v, want an authentication error", err)
+	}
+
+	if r.conn != nil {
+		t.Error("got a connection after failing to authenticate")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	r, servers := newRedis(t, Options{Channel: "qwbs"})
+
+	// The first connection is closed after one publish, so the next one is
+	// published on a fresh connection.
+	serve(t, servers, ":1\r\n")
+	if err := write(r); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	commands := serve(t, servers, ":1\r\n")
+	if err := write(r); err != nil {
+		t.Fatalf("got error %v after the connection was closed", err)
+	}
+	r.close()
+
+	if got := collect(commands); len(got) != 1 || got[0][0] != "PUBLISH" {
+		t.Errorf("got commands %q on the new connection, want one PUBLISH", got)
+	}
+}
+
+func TestWriteBusy(t *testing.T) {
+	r, _ := newRedis(t, Options{Channel: "qwbs"})
+
+	// A write waiting for a stalled one gives up with its context.
+	r.sem <- struct{}{}
+	defer r.unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	err := r.Write(ctx, nil, &writer.Data{
+		Broadcast: &broadcast.Broadcast{},
+		Server:    &serverstatus.Server{},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want the wait to time out", err)
+	}
+}
@@ -0,0 +1,325 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const timeFormat = "20060102T150405"
+
+type Options struct {
+	Path     string
+	MaxSize  int64
+	MaxAge   time.Duration
+	Keep     int
+	Compress bool
+}
+
+type File struct {
+	opts    Options
+	rotated *regexp.Regexp
+
+	mu        sync.Mutex
+	file      *os.File
+	closed    bool
+	size      int64
+	opened    time.Time
+	cleanup   sync.WaitGroup
+	cleanupMu sync.Mutex
+	errors    chan error
+}
+
+func New(opts Options) *File {
+	// Only the files named by rotate are considered when pruning, so that
+	// other files sharing the prefix are left alone.
+	rotated := regexp.MustCompile(`^` + regexp.QuoteMeta(filepath.Base(opts.Path)) +
+		`\.(\d{8}T\d{6})(?:\.(\d+))?(?:\.gz)?$`)
+
+	return &File{
+		opts:    opts,
+		rotated: rotated,
+		errors:  make(chan error, 1),
+	}
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	return f.write(p)
+}
+
+// Expire rotates the file if it's older than the maximum age, so that a file
+// that isn't written to is still rotated on time.
+func (f *File) Expire() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil || f.size == 0 || f.opts.MaxAge <= 0 || time.Since(f.opened) < f.opts.MaxAge {
+		return nil
+	}
+
+	return f.rotate()
+}
+
+// Reopen closes the current file so that it's reopened on the next write,
+// which allows external tools such as logrotate to move the file away.
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.close()
+}
+
+// Close closes the file, after which writes fail with os.ErrClosed rather
+// than opening it again.
+func (f *File) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	err := f.close()
+	f.mu.Unlock()
+
+	f.cleanup.Wait()
+	return err
+}
+
+// Err returns the last error from compressing or removing rotated files,
+// which is done in the background after the write that rotated the file.
+func (f *File) Err() error {
+	select {
+	case err := <-f.errors:
+		return err
+	default:
+		return nil
+	}
+}
+
+func (f *File) write(p []byte) (int, error) {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if f.shouldRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *File) report(err error) {
+	select {
+	case f.errors <- err:
+	default:
+	}
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q: %w", f.opts.Path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat file %q: %w", f.opts.Path, err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.created(info)
+	return nil
+}
+
+// created returns when the file was started, which is when the previous
+// file was rotated. The modification time is used for a file that has never
+// been rotated, since the creation time isn't available.
+func (f *File) created(info os.FileInfo) time.Time {
+	if info.Size() == 0 {
+		return time.Now()
+	}
+
+	files, err := f.rotatedFiles()
+	if err == nil && len(files) > 0 && files[0].time.Before(info.ModTime()) {
+		return files[0].time
+	}
+
+	return info.ModTime()
+}
+
+func (f *File) close() error {
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *File) shouldRotate(n int) bool {
+	if f.opts.MaxSize > 0 && f.size > 0 && f.size+int64(n) > f.opts.MaxSize {
+		return true
+	}
+
+	if f.opts.MaxAge > 0 && time.Since(f.opened) >= f.opts.MaxAge {
+		return true
+	}
+
+	return false
+}
+
+func (f *File) rotate() error {
+	if err := f.close(); err != nil {
+		return err
+	}
+
+	rotated := f.opts.Path + "." + time.Now().Format(timeFormat)
+	for i := 1; exists(rotated) || exists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s.%s.%d", f.opts.Path, time.Now().Format(timeFormat), i)
+	}
+
+	if err := os.Rename(f.opts.Path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate file %q: %w", f.opts.Path, err)
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	f.cleanup.Add(1)
+	go func() {
+		defer f.cleanup.Done()
+
+		if err := f.compressAndPrune(rotated); err != nil {
+			f.report(err)
+		}
+	}()
+
+	return nil
+}
+
+func (f *File) compressAndPrune(rotated string) error {
+	f.cleanupMu.Lock()
+	defer f.cleanupMu.Unlock()
+
+	if f.opts.Compress {
+		if err := compress(rotated); err != nil {
+			return err
+		}
+	}
+
+	if f.opts.Keep <= 0 {
+		return nil
+	}
+
+	files, err := f.rotatedFiles()
+	if err != nil {
+		return fmt.Errorf("failed to list rotated files: %w", err)
+	}
+
+	dir := filepath.Dir(f.opts.Path)
+	for _, file := range files[min(f.opts.Keep, len(files)):] {
+		if err := os.Remove(filepath.Join(dir, file.name)); err != nil {
+			return fmt.Errorf("failed to remove rotated file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+type rotatedFile struct {
+	name string
+	time time.Time
+	seq  int
+}
+
+// rotatedFiles returns the files rotated from the path, newest first.
+func (f *File) rotatedFiles() ([]rotatedFile, error) {
+	entries, err := os.ReadDir(filepath.Dir(f.opts.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []rotatedFile
+	for _, e := range entries {
+		m := f.rotated.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+
+		t, err := time.ParseInLocation(timeFormat, m[1], time.Local)
+		if err != nil {
+			continue
+		}
+
+		seq, _ := strconv.Atoi(m[2])
+		files = append(files, rotatedFile{name: e.Name(), time: t, seq: seq})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].time.Equal(files[j].time) {
+			return files[i].time.After(files[j].time)
+		}
+		return files[i].seq > files[j].seq
+	})
+
+	return files, nil
+}
+
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open rotated file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create compressed file: %w", err)
+	}
+
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return fmt.Errorf("failed to compress rotated file: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return fmt.Errorf("failed to compress rotated file: %w", err)
+	}
+
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to close compressed file: %w", err)
+	}
+
+	return os.Remove(path)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
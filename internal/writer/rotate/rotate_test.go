@@ -0,0 +1,279 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func write(t *testing.T, f *File, s string) {
+	t.Helper()
+
+	if _, err := f.Write([]byte(s)); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	return string(b)
+}
+
+// rotated returns the names of the rotated files, newest first.
+func rotated(t *testing.T, f *File) []string {
+	t.Helper()
+
+	files, err := f.rotatedFiles()
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	var names []string
+	for _, file := range files {
+		names = append(names, file.name)
+	}
+
+	return names
+}
+
+func TestRotateSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qwbs.log")
+	f := New(Options{Path: path, MaxSize: 10})
+
+	write(t, f, "first\n")
+	write(t, f, "second\n")
+	write(t, f, "third\n")
+	if err := f.Close(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if got := read(t, path); got != "third\n" {
+		t.Errorf("got %q in the file, want the last write", got)
+	}
+
+	names := rotated(t, f)
+	if len(names) != 2 {
+		t.Fatalf("got rotated files %q, want 2", names)
+	}
+
+	dir := filepath.Dir(path)
+	if got := read(t, filepath.Join(dir, names[0])); got != "second\n" {
+		t.Errorf("got %q in the newest rotated file, want the second write", got)
+	}
+	if got := read(t, filepath.Join(dir, names[1])); got != "first\n" {
+		t.Errorf("got %q in the oldest rotated file, want the first write", got)
+	}
+}
+
+func TestRotateSizeLargeWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qwbs.log")
+	f := New(Options{Path: path, MaxSize: 4})
+	defer f.Close()
+
+	// A write larger than the maximum size isn't split or rotated on its
+	// own into an empty file.
+	write(t, f, "larger than the limit\n")
+	if names := rotated(t, f); len(names) != 0 {
+		t.Errorf("got rotated files %q, want none", names)
+	}
+}
+
+func TestRotateAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qwbs.log")
+	f := New(Options{Path: path, MaxAge: time.Hour})
+	defer f.Close()
+
+	write(t, f, "first\n")
+	if err := f.Expire(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if names := rotated(t, f); len(names) != 0 {
+		t.Fatalf("got rotated files %q before the file expired", names)
+	}
+
+	// The file is rotated once it's too old, also without a write.
+	f.opened = time.Now().Add(-time.Hour)
+	if err := f.Expire(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if names := rotated(t, f); len(names) != 1 {
+		t.Fatalf("got rotated files %q, want 1", names)
+	}
+
+	// An expired file is also rotated when it's written to.
+	write(t, f, "second\n")
+	f.opened = time.Now().Add(-time.Hour)
+	write(t, f, "third\n")
+
+	if names := rotated(t, f); len(names) != 2 {
+		t.Errorf("got rotated files %q, want 2", names)
+	}
+	if got := read(t, path); got != "third\n" {
+		t.Errorf("got %q in the file, want the last write", got)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "qwbs.log")
+
+	// Files that aren't named by rotate are left alone.
+	old := []string{
+		"qwbs.log.20240101T000000",
+		"qwbs.log.20240102T000000",
+		"qwbs.log.20240102T000000.1.gz",
+		"qwbs.log.old",
+		"qwbs.logs.20240101T000000",
+	}
+	for _, name := range old {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := New(Options{Path: path, MaxSize: 1, Keep: 2})
+	write(t, f, "first\n")
+	write(t, f, "second\n")
+	if err := f.Close(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if err := f.Err(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	names := rotated(t, f)
+	if len(names) != 2 || names[1] != "qwbs.log.20240102T000000.1.gz" {
+		t.Errorf("got rotated files %q, want the new one and the newest old one", names)
+	}
+
+	for _, name := range []string{"qwbs.log.old", "qwbs.logs.20240101T000000"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("got error %v for a file that isn't rotated", err)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qwbs.log")
+	f := New(Options{Path: path, MaxSize: 1, Compress: true})
+
+	write(t, f, "first\n")
+	write(t, f, "second\n")
+	if err := f.Close(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	names := rotated(t, f)
+	if len(names) != 1 || filepath.Ext(names[0]) != ".gz" {
+		t.Fatalf("got rotated files %q, want one compressed file", names)
+	}
+
+	file, err := os.Open(filepath.Join(filepath.Dir(path), names[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "first\n" {
+		t.Errorf("got %q in the compressed file, want the first write", b)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "qwbs.log")
+	f := New(Options{Path: path})
+	defer f.Close()
+
+	write(t, f, "first\n")
+
+	// The file is moved away by an external tool and reopened.
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	write(t, f, "second\n")
+
+	if got := read(t, path); got != "second\n" {
+		t.Errorf("got %q in the reopened file, want the write after reopening", got)
+	}
+	if got := read(t, path+".moved"); got != "first\n" {
+		t.Errorf("got %q in the moved file, want the write before reopening", got)
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "qwbs.log")
+	f := New(Options{Path: path})
+
+	write(t, f, "first\n")
+	if err := f.Close(); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if _, err := f.Write([]byte("second\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("got error %v, want %v", err, os.ErrClosed)
+	}
+
+	// Reopening a closed file doesn't open it again either.
+	f.Reopen()
+	if _, err := f.Write([]byte("second\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("got error %v after reopening, want %v", err, os.ErrClosed)
+	}
+
+	if got := read(t, path); got != "first\n" {
+		t.Errorf("got %q in the file, want only the write before closing", got)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	dir := t.TempDir()
+	f := New(Options{Path: filepath.Join(dir, "missing", "qwbs.log")})
+	defer f.Close()
+
+	// The error is returned by the write that failed and isn't left for a
+	// later one.
+	if _, err := f.Write([]byte("first\n")); err == nil {
+		t.Fatal("got no error writing to a missing directory")
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "missing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write(t, f, "second\n")
+
+	if err := f.Err(); err != nil {
+		t.Errorf("got error %v after a successful write", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(dir, "missing"))
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !slices.Equal(names, []string{"qwbs.log"}) {
+		t.Errorf("got files %q, want only the log file", names)
+	}
+}
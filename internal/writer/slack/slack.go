@@ -0,0 +1,160 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/poster"
+	"github.com/osm/qwbs/internal/writer/textfmt"
+	"github.com/osm/qwbs/internal/writer/tmpl"
+)
+
+const (
+	maxTextLength    = 3000
+	maxContextLength = 10
+)
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var webhookErrors = map[string]string{
+	"action_prohibited":                 "the webhook is restricted from posting to the channel",
+	"channel_is_archived":               "the channel has been archived",
+	"channel_not_found":                 "the channel doesn't exist or is private",
+	"invalid_blocks":                    "the blocks are invalid",
+	"invalid_blocks_format":             "the blocks are malformed",
+	"invalid_payload":                   "the payload is malformed",
+	"invalid_token":                     "the webhook url is invalid",
+	"no_service":                        "the webhook is disabled or has been removed",
+	"no_service_id":                     "the webhook url is invalid",
+	"no_team":                           "the workspace doesn't exist",
+	"no_text":                           "the message has no text",
+	"posting_to_general_channel_denied": "the webhook isn't allowed to post in the channel",
+	"team_disabled":                     "the workspace has been disabled",
+	"too_many_attachments":              "the message has too many attachments",
+}
+
+type Payload struct {
+	Text   string  `json:"text"`
+	Blocks []Block `json:"blocks"`
+}
+
+type Block struct {
+	Type     string  `json:"type"`
+	Text     *Text   `json:"text,omitempty"`
+	Elements []*Text `json:"elements,omitempty"`
+}
+
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type Slack struct {
+	url      string
+	template *template.Template
+	client   *poster.Client
+}
+
+func New(url string, t *template.Template) *Slack {
+	return &Slack{
+		url:      url,
+		template: t,
+		client:   poster.NewClient(),
+	}
+}
+
+func (s *Slack) Write(ctx context.Context, _ *slog.Logger, data *writer.Data) error {
+	payload, err := s.payload(data)
+	if err != nil {
+		return fmt.Errorf("failed to format data: %w", err)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode Slack payload: %w", err)
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+
+	_, err = s.client.Do(ctx, http.MethodPost, s.url, header, body)
+	if err != nil {
+		return fmt.Errorf("failed to post to Slack: %w", webhookError(err))
+	}
+
+	return nil
+}
+
+func (s *Slack) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func (s *Slack) payload(data *writer.Data) (*Payload, error) {
+	bc := data.Broadcast
+	sv := data.Server
+
+	text := fmt.Sprintf("*%s*: %s", escape(bc.Name), escape(bc.Message))
+	if s.template != nil {
+		var err error
+		if text, err = tmpl.Execute(s.template, data); err != nil {
+			return nil, err
+		}
+	}
+
+	elements := []*Text{
+		mrkdwn(fmt.Sprintf("*Map:* %s", escape(sv.Map))),
+		mrkdwn(fmt.Sprintf("*Mode:* %s", escape(sv.Mode))),
+		mrkdwn(fmt.Sprintf("*Players:* %s/%s", data.Players(), data.MaxPlayers())),
+		mrkdwn(fmt.Sprintf("*Server:* `%s`", escape(bc.Address))),
+	}
+
+	if names := textfmt.Join(sv.Playing(), ", "); names != "" {
+		elements = append(elements, mrkdwn(escape(names)))
+	}
+
+	if len(elements) > maxContextLength {
+		elements = elements[:maxContextLength]
+	}
+
+	return &Payload{
+		Text: textfmt.Truncate(text, maxTextLength),
+		Blocks: []Block{
+			{Type: "section", Text: mrkdwn(textfmt.Truncate(text, maxTextLength))},
+			{Type: "context", Elements: elements},
+		},
+	}, nil
+}
+
+func webhookError(err error) error {
+	var statusErr *poster.StatusError
+	if !errors.As(err, &statusErr) {
+		return err
+	}
+
+	code := strings.TrimSpace(string(statusErr.Body))
+	if desc, ok := webhookErrors[code]; ok {
+		return fmt.Errorf("%w: %s (%s)", err, desc, code)
+	}
+
+	if code != "" {
+		return fmt.Errorf("%w: %s", err, code)
+	}
+
+	return err
+}
+
+func mrkdwn(text string) *Text {
+	return &Text{Type: "mrkdwn", Text: textfmt.Truncate(text, maxTextLength)}
+}
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
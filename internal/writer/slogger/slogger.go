@@ -2,31 +2,128 @@ package slogger
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"reflect"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/rotate"
+	"github.com/osm/qwbs/internal/writer/tmpl"
 )
 
+// expireInterval is how often the age of the log file is checked, so that it
+// is rotated on time also when no broadcasts are written.
+const expireInterval = time.Minute
+
 type Slogger struct {
-	logger *slog.Logger
+	logger   *slog.Logger
+	template *template.Template
+	file     *rotate.File
+}
+
+func New(logger *slog.Logger, t *template.Template, file *rotate.File) *Slogger {
+	return &Slogger{logger: logger, template: t, file: file}
+}
+
+func (s *Slogger) Write(ctx context.Context, logger *slog.Logger, data *writer.Data) error {
+	if err := s.write(ctx, data); err != nil {
+		return err
+	}
+
+	// Rotated files are compressed and pruned in the background, so their
+	// errors aren't the error of this write.
+	if s.file != nil {
+		if err := s.file.Err(); err != nil {
+			logger.Error("Failed to clean up rotated log files", "error", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Slogger) Run(ctx context.Context, logger *slog.Logger) {
+	if s.file == nil {
+		return
+	}
+
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.file.Expire(); err != nil {
+				logger.Error("Failed to rotate log file", "error", err)
+			}
+		case <-ctx.Done():
+			if err := s.file.Close(); err != nil {
+				logger.Error("Failed to close log file", "error", err)
+			}
+			return
+		}
+	}
+}
+
+func (s *Slogger) Close() error {
+	if s.file == nil {
+		return nil
+	}
+
+	return s.file.Close()
 }
 
-func New(logger *slog.Logger) *Slogger {
-	return &Slogger{logger: logger}
+func (s *Slogger) Reopen() error {
+	if s.file == nil {
+		return nil
+	}
+
+	return s.file.Reopen()
 }
 
-func (s *Slogger) Write(_ context.Context, _ *slog.Logger, data *writer.Data) {
+func (s *Slogger) write(ctx context.Context, data *writer.Data) error {
+	if s.template != nil {
+		msg, err := tmpl.Execute(s.template, data)
+		if err != nil {
+			return err
+		}
+
+		return s.log(ctx, msg)
+	}
+
 	var fields []any
 	val := reflect.ValueOf(data.Broadcast).Elem()
 	typ := val.Type()
 
 	for i := 0; i < val.NumField(); i++ {
+		if typ.Field(i).Tag.Get("json") == "-" {
+			continue
+		}
+
 		k := strings.ToLower(typ.Field(i).Name)
 		v := val.Field(i).Interface()
 		fields = append(fields, k, v)
 	}
 
-	s.logger.Info("Broadcast received", fields...)
+	return s.log(ctx, "Broadcast received", fields...)
+}
+
+// log logs the message through the handler rather than the logger, which
+// drops the error from writing it.
+func (s *Slogger) log(ctx context.Context, msg string, args ...any) error {
+	handler := s.logger.Handler()
+	if !handler.Enabled(ctx, slog.LevelInfo) {
+		return nil
+	}
+
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, msg, 0)
+	r.Add(args...)
+
+	if err := handler.Handle(ctx, r); err != nil {
+		return fmt.Errorf("failed to write to log: %w", err)
+	}
+
+	return nil
 }
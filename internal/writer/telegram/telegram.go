@@ -0,0 +1,205 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/poster"
+	"github.com/osm/qwbs/internal/writer/textfmt"
+	"github.com/osm/qwbs/internal/writer/tmpl"
+)
+
+const (
+	DefaultAPI       = "https://api.telegram.org"
+	maxMessageLength = 4096
+	parseMode        = "MarkdownV2"
+)
+
+var (
+	escaper     = strings.NewReplacer(markdownV2Pairs()...)
+	codeEscaper = strings.NewReplacer("\\", "\\\\", "`", "\\`")
+)
+
+type Message struct {
+	ChatID                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	ParseMode             string `json:"parse_mode"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+}
+
+type Response struct {
+	OK          bool                `json:"ok"`
+	ErrorCode   int                 `json:"error_code"`
+	Description string              `json:"description"`
+	Parameters  *ResponseParameters `json:"parameters,omitempty"`
+}
+
+type ResponseParameters struct {
+	RetryAfter int `json:"retry_after"`
+}
+
+type Telegram struct {
+	api      string
+	token    string
+	chats    []string
+	template *template.Template
+	client   *poster.Client
+}
+
+func New(api, token string, chats []string, t *template.Template) *Telegram {
+	if api == "" {
+		api = DefaultAPI
+	}
+
+	return &Telegram{
+		api:      strings.TrimSuffix(api, "/"),
+		token:    token,
+		chats:    chats,
+		template: t,
+		client:   poster.NewClient().WithRetryAfter(retryAfter),
+	}
+}
+
+// retryAfter returns the wait from a rate limited response, which the Bot API
+// gives in the response parameters.
+func retryAfter(respBody []byte) time.Duration {
+	var resp Response
+	if json.Unmarshal(respBody, &resp) != nil || resp.Parameters == nil {
+		return 0
+	}
+
+	return time.Duration(resp.Parameters.RetryAfter) * time.Second
+}
+
+func (t *Telegram) Write(ctx context.Context, _ *slog.Logger, data *writer.Data) error {
+	text, err := t.text(data)
+	if err != nil {
+		return fmt.Errorf("failed to format data: %w", err)
+	}
+
+	var errs []error
+	for _, chat := range t.chats {
+		if err := t.send(ctx, chat, text); err != nil {
+			errs = append(errs, fmt.Errorf("failed to send Telegram message to %s: %w", chat, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (t *Telegram) send(ctx context.Context, chat, text string) error {
+	body, err := json.Marshal(&Message{
+		ChatID:                chat,
+		Text:                  text,
+		ParseMode:             parseMode,
+		DisableWebPagePreview: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+
+	endpoint := fmt.Sprintf("%s/bot%s/sendMessage", t.api, t.token)
+	respBody, err := t.client.Do(ctx, http.MethodPost, endpoint, header, body)
+
+	return t.error(respBody, err)
+}
+
+func (t *Telegram) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+// Probe checks that the bot token is valid.
+func (t *Telegram) Probe(ctx context.Context) error {
+	endpoint := fmt.Sprintf("%s/bot%s/getMe", t.api, t.token)
+	respBody, err := t.client.Do(ctx, http.MethodGet, endpoint, nil, nil)
+
+	return t.error(respBody, err)
+}
+
+func (t *Telegram) error(respBody []byte, err error) error {
+	// Keep the bot token out of the logs.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = strings.ReplaceAll(urlErr.URL, t.token, "<token>")
+	}
+
+	var statusErr *poster.StatusError
+	if errors.As(err, &statusErr) {
+		var resp Response
+		if json.Unmarshal(respBody, &resp) == nil && resp.Description != "" {
+			return fmt.Errorf("%w: %s", err, resp.Description)
+		}
+	}
+
+	return err
+}
+
+// text formats the message. The length limit applies to the text after the
+// formatting has been parsed, so the parts are truncated before they're
+// escaped, which also avoids cutting an escape sequence or entity in half.
+func (t *Telegram) text(data *writer.Data) (string, error) {
+	if t.template != nil {
+		text, err := tmpl.Execute(t.template, data)
+		if err != nil {
+			return "", err
+		}
+
+		return Escape(truncate(text, maxMessageLength)), nil
+	}
+
+	bc := data.Broadcast
+	sv := data.Server
+
+	players := fmt.Sprintf("[%s/%s]", data.Players(), data.MaxPlayers())
+	names := textfmt.Join(sv.Playing(), ", ")
+
+	// The name, address, players and map are short, so the message and
+	// then the player names are truncated to what's left.
+	n := maxMessageLength - utf8.RuneCountInString(bc.Name+": \n"+bc.Address+" "+players+" "+sv.Map)
+	message := truncate(bc.Message, n)
+	n -= utf8.RuneCountInString(message) + len("\n")
+	names = truncate(names, n)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s*: %s\n", Escape(bc.Name), Escape(message))
+	fmt.Fprintf(&b, "`%s` %s %s", codeEscaper.Replace(bc.Address), Escape(players), Escape(sv.Map))
+
+	if names != "" {
+		fmt.Fprintf(&b, "\n_%s_", Escape(names))
+	}
+
+	return b.String(), nil
+}
+
+func Escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func markdownV2Pairs() []string {
+	var pairs []string
+	for _, c := range "\\_*[]()~`>#+-=|{}.!" {
+		pairs = append(pairs, string(c), "\\"+string(c))
+	}
+
+	return pairs
+}
+
+// truncate shortens s to at most n runes, where n may be negative if
+// nothing is left of the message.
+func truncate(s string, n int) string {
+	return textfmt.TruncateWith(s, max(n, 0), "…")
+}
@@ -0,0 +1,174 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/osm/qwbs/internal/qw/broadcast"
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/writer"
+)
+
+// botAPI is a local stand-in for the Bot API that records the messages and
+// replies with the queued responses, or 200 once they're used up.
+type botAPI struct {
+	*httptest.Server
+	mu        sync.Mutex
+	messages  []Message
+	responses []func(w http.ResponseWriter)
+}
+
+func newBotAPI(t *testing.T, responses ...func(w http.ResponseWriter)) *botAPI {
+	api := &botAPI{responses: responses}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bottoken/sendMessage" {
+			t.Errorf("got path %q", r.URL.Path)
+		}
+
+		var msg Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("failed to decode message: %v", err)
+		}
+
+		api.mu.Lock()
+		api.messages = append(api.messages, msg)
+		var respond func(w http.ResponseWriter)
+		if len(api.responses) > 0 {
+			respond, api.responses = api.responses[0], api.responses[1:]
+		}
+		api.mu.Unlock()
+
+		if respond != nil {
+			respond(w)
+			return
+		}
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	t.Cleanup(api.Close)
+
+	return api
+}
+
+func reply(status int, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+func testData(message string) *writer.Data {
+	return &writer.Data{
+		Broadcast: &broadcast.Broadcast{
+			Address:    "qw.example.com:28501",
+			MaxPlayers: "8",
+			Message:    message,
+			Name:       "Bob_",
+			Players:    "2",
+		},
+		Server: &serverstatus.Server{
+			Map:  "dm3",
+			Mode: "4on4",
+			Players: []serverstatus.Player{
+				{Name: "Bob_"},
+				{Name: "Alice"},
+				{Name: "spec", Spectator: true},
+			},
+		},
+	}
+}
+
+func write(t *testing.T, tg *Telegram, data *writer.Data) error {
+	t.Helper()
+	return tg.Write(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), data)
+}
+
+func TestWriteFormat(t *testing.T) {
+	api := newBotAPI(t)
+	tg := New(api.URL, "token", []string{"-100123"}, nil)
+
+	if err := write(t, tg, testData("need 2 more! (4on4)")); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	want := "*Bob\\_*: need 2 more\\! \\(4on4\\)\n" +
+		"`qw.example.com:28501` \\[2/8\\] dm3\n" +
+		"_Bob\\_, Alice_"
+
+	if len(api.messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(api.messages))
+	}
+
+	msg := api.messages[0]
+	if msg.Text != want || msg.ChatID != "-100123" || msg.ParseMode != parseMode || !msg.DisableWebPagePreview {
+		t.Errorf("got %+v, want text %q", msg, want)
+	}
+}
+
+func TestWriteTruncate(t *testing.T) {
+	api := newBotAPI(t)
+	tg := New(api.URL, "token", []string{"1"}, nil)
+
+	// Every character of the message needs escaping, so truncating the
+	// escaped text would cut escape sequences in half.
+	if err := write(t, tg, testData(strings.Repeat("_.", maxMessageLength))); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	text := api.messages[0].Text
+	parsed := strings.NewReplacer("\\\\", "\\", "\\", "", "*", "", "`", "").Replace(text)
+	if n := utf8.RuneCountInString(parsed); n > maxMessageLength {
+		t.Errorf("got %d characters, want at most %d", n, maxMessageLength)
+	}
+
+	message := strings.SplitN(text, "\n", 2)[0]
+	if !strings.HasSuffix(message, "\\_\\.…") && !strings.HasSuffix(message, "\\.\\_…") {
+		t.Errorf("got message ending in %q, want complete escapes", message[len(message)-10:])
+	}
+}
+
+func TestWriteRetryAfter(t *testing.T) {
+	api := newBotAPI(t, reply(http.StatusTooManyRequests,
+		`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`))
+	tg := New(api.URL, "token", []string{"1"}, nil)
+
+	start := time.Now()
+	if err := write(t, tg, testData("hello")); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+
+	if len(api.messages) != 2 {
+		t.Errorf("got %d requests, want the message to be retried once", len(api.messages))
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want retry_after to be honoured", elapsed)
+	}
+}
+
+func TestWriteChats(t *testing.T) {
+	api := newBotAPI(t, reply(http.StatusBadRequest,
+		`{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`))
+	tg := New(api.URL, "token", []string{"@missing", "-100123", "@qwpickup"}, nil)
+
+	err := write(t, tg, testData("hello"))
+	if err == nil || !strings.Contains(err.Error(), "@missing") || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("got error %v, want the failed chat and the API description", err)
+	}
+
+	var chats []string
+	for _, msg := range api.messages {
+		chats = append(chats, msg.ChatID)
+	}
+	if strings.Join(chats, " ") != "@missing -100123 @qwpickup" {
+		t.Errorf("got messages to %q, want every chat despite the failure", chats)
+	}
+}
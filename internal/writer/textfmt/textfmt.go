@@ -0,0 +1,68 @@
+package textfmt
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+)
+
+// Truncate shortens s to at most n runes, ending a truncated string with
+// "...".
+func Truncate(s string, n int) string {
+	return TruncateWith(s, n, "...")
+}
+
+// TruncateWith shortens s to at most n runes. The end of a truncated string
+// is replaced by ellipsis, unless n is too small to fit it. A negative n
+// leaves s unchanged.
+func TruncateWith(s string, n int, ellipsis string) string {
+	r := []rune(s)
+	if n < 0 || len(r) <= n {
+		return s
+	}
+
+	e := utf8.RuneCountInString(ellipsis)
+	if n <= e {
+		return string(r[:n])
+	}
+
+	return string(r[:n-e]) + ellipsis
+}
+
+// TruncateBytes shortens s to at most n bytes, cutting on a rune boundary to
+// avoid producing broken UTF-8.
+func TruncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
+
+// Join returns the names of the players separated by sep.
+func Join(players []serverstatus.Player, sep string) string {
+	names := make([]string, len(players))
+	for i, p := range players {
+		names[i] = p.Name
+	}
+
+	return strings.Join(names, sep)
+}
+
+// List returns the names of the players as a list, e.g. "a, b and c".
+func List(players []serverstatus.Player) string {
+	n := len(players)
+	switch n {
+	case 0:
+		return ""
+	case 1:
+		return players[0].Name
+	default:
+		return Join(players[:n-1], ", ") + " and " + players[n-1].Name
+	}
+}
@@ -0,0 +1,67 @@
+package tmpl
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/osm/qwbs/internal/qw/charset"
+	"github.com/osm/qwbs/internal/qw/serverstatus"
+	"github.com/osm/qwbs/internal/writer"
+	"github.com/osm/qwbs/internal/writer/textfmt"
+)
+
+var funcs = template.FuncMap{
+	"charset":  charset.Parse,
+	"date":     date,
+	"join":     join,
+	"lower":    strings.ToLower,
+	"names":    textfmt.List,
+	"now":      time.Now,
+	"trim":     strings.TrimSpace,
+	"truncate": truncate,
+	"upper":    strings.ToUpper,
+}
+
+func Parse(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %q: %w", name, err)
+	}
+
+	return t, nil
+}
+
+func ParseFile(path string) (*template.Template, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template file %q: %w", path, err)
+	}
+
+	return Parse(filepath.Base(path), string(text))
+}
+
+func Execute(t *template.Template, data *writer.Data) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute template %q: %w", t.Name(), err)
+	}
+
+	return buf.String(), nil
+}
+
+func date(layout string, t time.Time) string {
+	return t.Format(layout)
+}
+
+func join(sep string, players []serverstatus.Player) string {
+	return textfmt.Join(players, sep)
+}
+
+func truncate(n int, s string) string {
+	return textfmt.Truncate(s, n)
+}
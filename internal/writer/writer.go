@@ -3,7 +3,10 @@ package writer
 import (
 	"context"
 	"log/slog"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/osm/qwbs/internal/qw/broadcast"
 	"github.com/osm/qwbs/internal/qw/serverstatus"
@@ -12,6 +15,7 @@ import (
 type Data struct {
 	Broadcast *broadcast.Broadcast `json:"broadcast"`
 	Server    *serverstatus.Server `json:"server"`
+	Time      time.Time            `json:"time"`
 }
 
 func (d *Data) MaxPlayers() string {
@@ -27,9 +31,63 @@ func (d *Data) Players() string {
 		return d.Broadcast.Players
 	}
 
-	return strconv.Itoa(len(d.Server.Players))
+	return strconv.Itoa(len(d.Server.Playing()))
+}
+
+// Filter selects the broadcasts delivered to a writer. A broadcast matches
+// when its server address is one of Servers and the server mode is one of
+// Modes, an empty list matches everything.
+type Filter struct {
+	Servers []string
+	Modes   []string
+}
+
+func (f Filter) Match(data *Data) bool {
+	if len(f.Servers) > 0 && !slices.Contains(f.Servers, data.Broadcast.Address) {
+		return false
+	}
+
+	if len(f.Modes) > 0 && !slices.ContainsFunc(f.Modes, func(mode string) bool {
+		return strings.EqualFold(mode, data.Server.Mode)
+	}) {
+		return false
+	}
+
+	return true
 }
 
 type Writer interface {
-	Write(ctx context.Context, logger *slog.Logger, data *Data)
+	Write(ctx context.Context, logger *slog.Logger, data *Data) error
+
+	// Close releases what the writer holds, such as a database handle or
+	// idle connections. It's called for writers that are discarded without
+	// being run, running writers release their resources when Run returns.
+	Close() error
+}
+
+// Delivery is called with the result of delivering a broadcast that was
+// queued at the given time.
+type Delivery func(queued time.Time, err error)
+
+// Queuer is implemented by writers that deliver broadcasts in the background.
+// Their Write only queues the broadcast and the result of the delivery is
+// reported to the Delivery set with SetDelivery.
+type Queuer interface {
+	SetDelivery(delivery Delivery)
+}
+
+type Runner interface {
+	Run(ctx context.Context, logger *slog.Logger)
+}
+
+type Reopener interface {
+	Reopen() error
+}
+
+type Checker interface {
+	Check() error
+}
+
+type Prober interface {
+	Probe(ctx context.Context) error
 }
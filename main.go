@@ -10,44 +10,97 @@ import (
 	"syscall"
 
 	"github.com/osm/qwbs/internal/config"
+	"github.com/osm/qwbs/internal/logging"
 	"github.com/osm/qwbs/internal/server"
 	"github.com/osm/qwbs/internal/version"
 )
 
 func main() {
-	configFile := flag.String("config-file", "./qwbs.conf", "Path to config file")
-	flag.Parse()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check-config":
+			os.Exit(checkConfig(os.Args[2:]))
+		case "convert-config":
+			os.Exit(convertConfig(os.Args[2:]))
+		}
+	}
+
+	opts := parseFlags(flag.CommandLine, os.Args[1:])
 
-	conf, err := config.FromFile(*configFile)
+	conf, configFile, overrides, err := opts.load()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to parse config file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
 		os.Exit(1)
 	}
 
-	var logLevel slog.LevelVar
-	logLevel.Set(slog.LevelInfo)
-	if conf.Debug {
-		logLevel.Set(slog.LevelDebug)
+	lg, err := logging.New(conf.Logging)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create logger: %v\n", err)
+		os.Exit(1)
 	}
-	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &logLevel}))
+	defer lg.Close()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	logger := lg.Logger()
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigCh
-		cancel()
-	}()
+	for _, w := range conf.Warnings {
+		logger.Warn(w)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 
 	logger.Info(version.Name(),
 		"listen-addr", conf.ListenAddress,
 		"version", version.Short(),
 		"writers", len(conf.Writers))
 
-	srv := server.New(logger, conf.ListenAddress, conf.MasterAddresses, conf.Writers)
+	srv := server.New(logger, conf)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range sigCh {
+			if sig == syscall.SIGHUP {
+				reload(logger, lg, srv, configFile, overrides)
+				continue
+			}
+
+			cancel()
+			return
+		}
+	}()
 	if err := srv.ListenAndServe(ctx); err != nil {
 		logger.Error("ListenAndServe failed", "error", err)
 		os.Exit(1)
 	}
 }
+
+func reload(
+	logger *slog.Logger,
+	lg *logging.Logging,
+	srv *server.Server,
+	configFile string,
+	overrides []config.Override) {
+	logger.Info("Reloading configuration", "config-file", configFile)
+
+	conf, err := config.Load(configFile, overrides)
+	if err == nil {
+		for _, w := range conf.Warnings {
+			logger.Warn(w)
+		}
+	}
+
+	if err != nil {
+		logger.Error("Failed to reload configuration, keeping the current configuration", "error", err)
+	} else if err := srv.Reload(conf); err != nil {
+		conf.CloseWriters()
+		logger.Error("Failed to apply configuration", "error", err)
+	} else {
+		lg.Reload(conf.Logging)
+	}
+
+	logger.Info("Reopening log files")
+	if err := lg.Reopen(); err != nil {
+		logger.Error("Failed to reopen log file", "error", err)
+	}
+	srv.Reopen()
+}